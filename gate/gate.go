@@ -1,20 +1,185 @@
 package gate
 
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWeight 权重小于1或超过Gate的大小, 永远无法获得许可
+var ErrWeight = errors.New("gate: weight must be between 1 and the gate size")
+
+// Gate 限制并行数量, 支持权重, 取消和运行时调整大小
+// 等待的调用按先后顺序获得许可
 type Gate struct {
-	ch chan struct{}
+	mu   sync.Mutex
+	size int
+	// 已获得许可的权重之和
+	cur     int
+	waiters list.List
+}
+
+type waiter struct {
+	n     int
+	ready chan struct{}
+	// Resize 调小后权重超过大小时为 ErrWeight
+	err error
 }
 
 // 限制并行数量
 func New(num int) *Gate {
-	return &Gate{
-		ch: make(chan struct{}, num),
+	if num < 1 {
+		num = 1
 	}
+	return &Gate{size: num}
 }
 
+// Enter 获取一个许可, 没有空闲时一直等待
 func (g *Gate) Enter() {
-	g.ch <- struct{}{}
+	g.AcquireN(context.Background(), 1)
 }
 
+// Leave 释放Enter获取的许可
 func (g *Gate) Leave() {
-	<-g.ch
+	g.ReleaseN(1)
+}
+
+// Acquire 获取一个许可, ctx 取消时返回 ctx.Err()
+func (g *Gate) Acquire(ctx context.Context) error {
+	return g.AcquireN(ctx, 1)
+}
+
+// AcquireN 获取权重为n的许可
+// n 小于1或超过大小时返回 ErrWeight; 等待期间 Resize 调小到小于n时也返回 ErrWeight
+func (g *Gate) AcquireN(ctx context.Context, n int) error {
+	g.mu.Lock()
+	if n < 1 || n > g.size {
+		g.mu.Unlock()
+		return ErrWeight
+	}
+	if g.waiters.Len() == 0 && g.cur+n <= g.size {
+		g.cur += n
+		g.mu.Unlock()
+		return nil
+	}
+
+	w := &waiter{n: n, ready: make(chan struct{})}
+	elem := g.waiters.PushBack(w)
+	g.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		select {
+		case <-w.ready:
+			// 取消的同时已经获得许可, 还回去
+			if w.err == nil {
+				g.cur -= n
+			}
+		default:
+			g.waiters.Remove(elem)
+		}
+		g.notify()
+		g.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire 获取一个许可, 不等待
+func (g *Gate) TryAcquire() bool {
+	return g.TryAcquireN(1)
+}
+
+// TryAcquireN 获取权重为n的许可, 不等待
+func (g *Gate) TryAcquireN(n int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if n >= 1 && g.waiters.Len() == 0 && g.cur+n <= g.size {
+		g.cur += n
+		return true
+	}
+	return false
+}
+
+// Release 释放一个许可
+func (g *Gate) Release() {
+	g.ReleaseN(1)
+}
+
+// ReleaseN 释放权重为n的许可
+func (g *Gate) ReleaseN(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.cur -= n
+	if g.cur < 0 {
+		panic("gate: released more than held")
+	}
+	g.notify()
+}
+
+// Resize 调整并行数量; 调小时已获得许可的调用不受影响, 新的调用等待到低于新的大小
+// 等待中权重超过新大小的调用返回 ErrWeight, 否则会一直挡住后面的调用
+func (g *Gate) Resize(num int) {
+	if num < 1 {
+		num = 1
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.size = num
+	for e := g.waiters.Front(); e != nil; {
+		next := e.Next()
+		if w := e.Value.(*waiter); w.n > num {
+			w.err = ErrWeight
+			g.waiters.Remove(e)
+			close(w.ready)
+		}
+		e = next
+	}
+	g.notify()
+}
+
+// Size 并行数量
+func (g *Gate) Size() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.size
+}
+
+// InFlight 已获得许可的权重之和
+func (g *Gate) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cur
+}
+
+// Waiting 正在等待许可的调用数量
+func (g *Gate) Waiting() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.waiters.Len()
+}
+
+// 按顺序唤醒等待的调用, 需要持有锁
+func (g *Gate) notify() {
+	for {
+		front := g.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*waiter)
+		if g.cur+w.n > g.size {
+			// 先到的调用优先, 后面权重小的也不能插队
+			return
+		}
+		g.cur += w.n
+		g.waiters.Remove(front)
+		close(w.ready)
+	}
 }
@@ -0,0 +1,178 @@
+package gate
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGateLimit(t *testing.T) {
+	g := New(3)
+
+	var cur, max int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Enter()
+			defer g.Leave()
+
+			n := atomic.AddInt32(&cur, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&cur, -1)
+		}()
+	}
+	wg.Wait()
+
+	if max > 3 {
+		t.Fatalf("max concurrency = %d, want <= 3", max)
+	}
+	if g.InFlight() != 0 || g.Waiting() != 0 {
+		t.Fatalf("inflight = %d, waiting = %d", g.InFlight(), g.Waiting())
+	}
+}
+
+func TestGateAcquireCancel(t *testing.T) {
+	g := New(1)
+	if !g.TryAcquire() {
+		t.Fatal("TryAcquire on empty gate failed")
+	}
+	if g.TryAcquire() {
+		t.Fatal("TryAcquire on full gate succeeded")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := g.Acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Acquire = %v, want deadline exceeded", err)
+	}
+	if g.Waiting() != 0 {
+		t.Fatalf("canceled waiter not removed, waiting = %d", g.Waiting())
+	}
+
+	g.Release()
+	if err := g.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGateWeightedFIFO(t *testing.T) {
+	g := New(4)
+	g.AcquireN(context.Background(), 3)
+
+	acquired := make(chan struct{}, 2)
+	go func() {
+		g.AcquireN(context.Background(), 2)
+		acquired <- struct{}{}
+	}()
+	waitFor(t, func() bool { return g.Waiting() == 1 })
+
+	// 先到的权重2在等待, 权重1不能插队
+	if g.TryAcquireN(1) {
+		t.Fatal("TryAcquireN jumped the queue")
+	}
+	go func() {
+		g.AcquireN(context.Background(), 1)
+		acquired <- struct{}{}
+	}()
+	waitFor(t, func() bool { return g.Waiting() == 2 })
+
+	g.ReleaseN(3)
+	<-acquired
+	<-acquired
+	if g.InFlight() != 3 {
+		t.Fatalf("inflight = %d, want 3", g.InFlight())
+	}
+}
+
+func TestGateInvalidWeight(t *testing.T) {
+	g := New(2)
+	for _, n := range []int{0, -1, 3} {
+		if err := g.AcquireN(context.Background(), n); err != ErrWeight {
+			t.Errorf("AcquireN(%d) = %v, want ErrWeight", n, err)
+		}
+		if g.TryAcquireN(n) {
+			t.Errorf("TryAcquireN(%d) succeeded", n)
+		}
+	}
+	if g.InFlight() != 0 || g.Waiting() != 0 {
+		t.Fatalf("inflight = %d, waiting = %d", g.InFlight(), g.Waiting())
+	}
+	if !g.TryAcquireN(2) {
+		t.Fatal("gate blocked by rejected weights")
+	}
+}
+
+func TestGateResizeBelowWaiter(t *testing.T) {
+	g := New(4)
+	g.AcquireN(context.Background(), 3)
+
+	errs := make(chan error, 2)
+	go func() { errs <- g.AcquireN(context.Background(), 4) }()
+	waitFor(t, func() bool { return g.Waiting() == 1 })
+	go func() { errs <- g.AcquireN(context.Background(), 1) }()
+	waitFor(t, func() bool { return g.Waiting() == 2 })
+
+	// 权重4永远无法获得许可, 不能挡住后面的调用
+	g.Resize(2)
+	if err := <-errs; err != ErrWeight {
+		t.Fatalf("waiter over the new size = %v, want ErrWeight", err)
+	}
+	g.ReleaseN(3)
+	if err := <-errs; err != nil {
+		t.Fatalf("waiter behind it = %v", err)
+	}
+	if g.InFlight() != 1 || g.Waiting() != 0 {
+		t.Fatalf("inflight = %d, waiting = %d", g.InFlight(), g.Waiting())
+	}
+}
+
+func TestGateResize(t *testing.T) {
+	g := New(1)
+	g.Enter()
+
+	done := make(chan struct{})
+	go func() {
+		g.Enter()
+		close(done)
+	}()
+	waitFor(t, func() bool { return g.Waiting() == 1 })
+
+	g.Resize(2)
+	<-done
+	if g.Size() != 2 || g.InFlight() != 2 {
+		t.Fatalf("size = %d, inflight = %d", g.Size(), g.InFlight())
+	}
+
+	// 调小后需要等到低于新的大小
+	g.Resize(1)
+	g.Leave()
+	if g.TryAcquire() {
+		t.Fatal("TryAcquire succeeded while over the new size")
+	}
+	g.Leave()
+	if !g.TryAcquire() {
+		t.Fatal("TryAcquire failed after shrinking below the new size")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
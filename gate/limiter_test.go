@@ -0,0 +1,123 @@
+package gate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiterRate(t *testing.T) {
+	l := NewLimiter(100, 2)
+
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 前2个来自burst, 后10个每10ms一个
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Fatalf("12 tokens took %v, want >= 100ms", d)
+	}
+
+	if l.Allow() {
+		t.Fatal("Allow succeeded with an empty bucket")
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := NewLimiter(1, 1)
+	l.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait = %v, want deadline exceeded", err)
+	}
+
+	var nilLimiter *Limiter
+	if !nilLimiter.Allow() || nilLimiter.Wait(context.Background()) != nil {
+		t.Fatal("nil limiter should not limit")
+	}
+	if NewLimiter(0, 1) != nil {
+		t.Fatal("zero rate should return nil limiter")
+	}
+}
+
+func TestSubnetOf(t *testing.T) {
+	cases := map[string]string{
+		"10.1.2.3":        "10.1.2.0/24",
+		"10.1.2.250":      "10.1.2.0/24",
+		"2001:db8::1:2:3": "2001:db8::/64",
+		"web01":           "",
+	}
+	for ip, want := range cases {
+		if got := SubnetOf(ip); got != want {
+			t.Errorf("SubnetOf(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestLimitsSubnet(t *testing.T) {
+	l := NewLimits(New(10), nil, Limit{Parallel: 2}, Limit{Parallel: 1})
+
+	var mu sync.Mutex
+	cur := map[string]int{}
+	max := 0
+
+	var wg sync.WaitGroup
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.1.1", "10.0.1.2", "10.0.1.3"}
+	for _, ip := range hosts {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			release, err := l.Acquire(context.Background(), ip, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+
+			key := SubnetOf(ip)
+			mu.Lock()
+			cur[key]++
+			if cur[key] > max {
+				max = cur[key]
+			}
+			mu.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mu.Lock()
+			cur[key]--
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+
+	if max > 2 {
+		t.Fatalf("max per subnet = %d, want <= 2", max)
+	}
+
+	// 同一个跳板机只允许1个
+	release, err := l.Acquire(context.Background(), "10.0.2.1", "bastion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "10.0.3.1", "bastion"); err == nil {
+		t.Fatal("second host through the same jump host should wait")
+	}
+	if r, err := l.Acquire(context.Background(), "10.0.3.1", "other"); err != nil {
+		t.Fatal(err)
+	} else {
+		r()
+	}
+	release()
+
+	if l.global.g.InFlight() != 0 {
+		t.Fatalf("global gate not released, inflight = %d", l.global.g.InFlight())
+	}
+}
@@ -0,0 +1,123 @@
+package gate
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// Limit 一个层级的限制, 0 表示不限制
+type Limit struct {
+	// 并发数量
+	Parallel int
+	// 每秒新建连接的数量
+	Rate float64
+	// 速率限制最多积攒的令牌数, 小于1时为1
+	Burst int
+}
+
+// 一个网段或一个跳板机的限制
+type scope struct {
+	g *Gate
+	l *Limiter
+}
+
+func newScope(l Limit) *scope {
+	s := &scope{l: NewLimiter(l.Rate, l.Burst)}
+	if l.Parallel > 0 {
+		s.g = New(l.Parallel)
+	}
+	return s
+}
+
+// Limits 分层限制: 全局, 每个/24网段(IPv6为/64), 每个跳板机
+// 同一台主机需要同时满足所有层级的限制
+type Limits struct {
+	global *scope
+	subnet Limit
+	jump   Limit
+
+	mu      sync.Mutex
+	subnets map[string]*scope
+	jumps   map[string]*scope
+}
+
+// NewLimits g 为全局并发限制, 可以为空; rate 为全局速率限制, 可以为空
+func NewLimits(g *Gate, rate *Limiter, subnet, jump Limit) *Limits {
+	return &Limits{
+		global:  &scope{g: g, l: rate},
+		subnet:  subnet,
+		jump:    jump,
+		subnets: make(map[string]*scope),
+		jumps:   make(map[string]*scope),
+	}
+}
+
+// SubnetOf IPv4 地址所在的/24网段, IPv6 地址所在的/64网段; 不是IP时返回空
+func SubnetOf(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: addr.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+func (l *Limits) scope(m map[string]*scope, key string, limit Limit) *scope {
+	if key == "" || limit == (Limit{}) {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := m[key]
+	if !ok {
+		s = newScope(limit)
+		m[key] = s
+	}
+	return s
+}
+
+// Acquire 获取主机ip的许可, jump 为跳板机, 没有时为空
+// 先获取范围小的并发许可, 再获取全局并发许可, 最后等待速率限制
+// 成功时返回释放许可的函数
+func (l *Limits) Acquire(ctx context.Context, ip, jump string) (func(), error) {
+	scopes := []*scope{
+		l.scope(l.subnets, SubnetOf(ip), l.subnet),
+		l.scope(l.jumps, jump, l.jump),
+		l.global,
+	}
+
+	held := make([]*Gate, 0, len(scopes))
+	release := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Leave()
+		}
+	}
+
+	for _, s := range scopes {
+		if s == nil || s.g == nil {
+			continue
+		}
+		if err := s.g.Acquire(ctx); err != nil {
+			release()
+			return nil, err
+		}
+		held = append(held, s.g)
+	}
+
+	for _, s := range scopes {
+		if s == nil {
+			continue
+		}
+		if err := s.l.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	return release, nil
+}
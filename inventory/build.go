@@ -0,0 +1,150 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 主机条目, 展开前的表达式和主机变量
+type entry struct {
+	expr  string
+	group string
+	vars  map[string]string
+	// 出错时提示的位置, file:line
+	where string
+}
+
+// 解析清单文件得到的中间结果, INI 和 YAML 共用
+type builder struct {
+	entries   []entry
+	groups    []string
+	groupVars map[string]map[string]string
+	children  map[string][]string
+}
+
+func newBuilder() *builder {
+	return &builder{
+		groupVars: make(map[string]map[string]string),
+		children:  make(map[string][]string),
+	}
+}
+
+func (b *builder) addGroup(name string) {
+	for _, g := range b.groups {
+		if g == name {
+			return
+		}
+	}
+	b.groups = append(b.groups, name)
+}
+
+func (b *builder) setGroupVar(group, key, value string) {
+	b.addGroup(group)
+	if b.groupVars[group] == nil {
+		b.groupVars[group] = make(map[string]string)
+	}
+	b.groupVars[group][key] = value
+}
+
+// 展开主机, 处理子组, 按 all < 组 < 主机 的优先级设置变量
+func (b *builder) build() (*Inventory, error) {
+	inv := newInventory()
+	hostVars := make(map[string]map[string]string)
+
+	for _, e := range b.entries {
+		names, err := ExpandHosts(e.expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.where, err)
+		}
+		for _, name := range names {
+			if _, err := inv.add(name, e.group, nil); err != nil {
+				return nil, fmt.Errorf("%s: %w", e.where, err)
+			}
+			if hostVars[name] == nil {
+				hostVars[name] = make(map[string]string)
+			}
+			for k, v := range e.vars {
+				hostVars[name][k] = v
+			}
+		}
+	}
+
+	for _, parent := range b.groups {
+		for _, h := range b.members(inv, parent, map[string]bool{}) {
+			inv.add(h.Name, parent, nil)
+		}
+	}
+
+	for _, h := range inv.hosts {
+		vars := make(map[string]string)
+		for k, v := range b.groupVars["all"] {
+			vars[k] = v
+		}
+		for _, g := range b.groups {
+			if !h.inGroup(g) {
+				continue
+			}
+			for k, v := range b.groupVars[g] {
+				vars[k] = v
+			}
+		}
+		for k, v := range hostVars[h.Name] {
+			vars[k] = v
+		}
+		for k, v := range vars {
+			if err := h.set(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return inv, nil
+}
+
+// 组及其子组中的所有主机
+func (b *builder) members(inv *Inventory, group string, seen map[string]bool) []*Host {
+	if seen[group] {
+		return nil
+	}
+	seen[group] = true
+
+	hosts := append([]*Host(nil), inv.groups[group]...)
+	for _, child := range b.children[group] {
+		hosts = append(hosts, b.members(inv, child, seen)...)
+	}
+	return hosts
+}
+
+// Load 读取主机清单文件, 从idc数据库读取使用 LoadDB
+//
+//	hosts.yaml, hosts.json  YAML 格式
+//	其它文件                INI 格式
+func Load(source string) (*Inventory, error) {
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: read [%s] failed, err=%#v", source, err.Error())
+	}
+
+	var b *builder
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".yaml", ".yml", ".json":
+		b, err = parseYAML(source, data)
+	default:
+		b, err = parseINI(source, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.build()
+}
+
+// FromHosts 由主机表达式列表生成清单, 用于命令行的 -ip 和 -filename
+func FromHosts(exprs []string) (*Inventory, error) {
+	b := newBuilder()
+	for _, e := range exprs {
+		b.entries = append(b.entries, entry{expr: e, where: e})
+	}
+	return b.build()
+}
@@ -0,0 +1,41 @@
+package inventory
+
+import (
+	db "zeus/model"
+)
+
+// LoadDB 从idc数据库读取主机, 以业务名称分组, sn/model/label/cabinet 作为标签
+// 资产信息表 idc_machine_info 由升级脚本创建, 表结构不是最新版本时返回错误
+func LoadDB(store db.Store) (*Inventory, error) {
+	if err := store.CheckSchema(); err != nil {
+		return nil, err
+	}
+
+	rows, err := store.QueryHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBuilder()
+	for _, r := range rows {
+		if r.IP == "" {
+			continue
+		}
+		b.entries = append(b.entries, entry{
+			expr:  r.IP,
+			group: r.ServiceName,
+			vars: map[string]string{
+				"sn":      r.SN,
+				"model":   r.Model,
+				"label":   r.Label,
+				"cabinet": r.Cabinet,
+			},
+			where: "db: sn " + r.SN,
+		})
+		if r.ServiceName != "" {
+			b.addGroup(r.ServiceName)
+		}
+	}
+
+	return b.build()
+}
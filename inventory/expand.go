@@ -0,0 +1,102 @@
+package inventory
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 一次展开的主机数量上限, 防止写错网段时生成过多主机
+const maxExpand = 65536
+
+var rangeRe = regexp.MustCompile(`\[(\d+)-(\d+)\]`)
+
+// ExpandHosts 展开主机表达式
+//
+//	10.0.0.1          单个主机
+//	10.0.0.[1-50]     范围, 可以出现多次, 例如 10.0.[1-2].[1-10]
+//	web[01-10]        带前导0的范围保持位数
+//	10.0.0.0/28       CIDR, 不包含网络地址和广播地址(/31 /32 除外)
+func ExpandHosts(expr string) ([]string, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("inventory: empty host expression")
+	}
+
+	if strings.Contains(expr, "/") {
+		return expandCIDR(expr)
+	}
+
+	hosts := []string{expr}
+	for {
+		m := rangeRe.FindStringSubmatchIndex(hosts[0])
+		if m == nil {
+			break
+		}
+
+		startStr := hosts[0][m[2]:m[3]]
+		endStr := hosts[0][m[4]:m[5]]
+		start, err1 := strconv.Atoi(startStr)
+		end, err2 := strconv.Atoi(endStr)
+		if err1 != nil || err2 != nil || start > end {
+			return nil, fmt.Errorf("inventory: invalid range [%s-%s] in %q", startStr, endStr, expr)
+		}
+		// 先单独检查范围大小, end-start+1 和相乘都可能溢出
+		if end-start >= maxExpand || len(hosts)*(end-start+1) > maxExpand {
+			return nil, fmt.Errorf("inventory: %q expands to more than %d hosts", expr, maxExpand)
+		}
+
+		// 起始值带前导0时保持位数
+		format := "%d"
+		if len(startStr) > 1 && startStr[0] == '0' {
+			format = fmt.Sprintf("%%0%dd", len(startStr))
+		}
+
+		next := make([]string, 0, len(hosts)*(end-start+1))
+		for _, h := range hosts {
+			loc := rangeRe.FindStringIndex(h)
+			for i := start; i <= end; i++ {
+				next = append(next, h[:loc[0]]+fmt.Sprintf(format, i)+h[loc[1]:])
+			}
+		}
+		hosts = next
+	}
+
+	if strings.ContainsAny(hosts[0], "[]") {
+		return nil, fmt.Errorf("inventory: invalid host expression %q", expr)
+	}
+
+	return hosts, nil
+}
+
+func expandCIDR(expr string) ([]string, error) {
+	ip, ipnet, err := net.ParseCIDR(expr)
+	if err != nil {
+		return nil, fmt.Errorf("inventory: invalid CIDR %q", expr)
+	}
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("inventory: only IPv4 CIDR is supported: %q", expr)
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("inventory: %q expands to more than %d hosts", expr, maxExpand)
+	}
+
+	base := ipnet.IP.To4()
+	n := uint32(1) << uint(bits-ones)
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+
+	hosts := make([]string, 0, n)
+	for i := uint32(0); i < n; i++ {
+		// 跳过网络地址和广播地址
+		if n > 2 && (i == 0 || i == n-1) {
+			continue
+		}
+		v := start + i
+		hosts = append(hosts, net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String())
+	}
+	return hosts, nil
+}
@@ -0,0 +1,100 @@
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/*
+INI 格式:
+
+	# 不属于任何组的主机
+	10.0.9.1 port=2222
+
+	[web]
+	10.0.0.[1-50] user=deploy
+	web-vip host=10.0.0.100 role=vip
+
+	[web:vars]
+	jump=root@10.0.255.1:22
+
+	[db]
+	10.0.1.0/28
+
+	[prod:children]
+	web
+	db
+
+	[all:vars]
+	user=root
+*/
+func parseINI(file string, data []byte) (*builder, error) {
+	b := newBuilder()
+
+	const (
+		hostsSection = iota
+		varsSection
+		childrenSection
+	)
+	section, group := hostsSection, ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		where := fmt.Sprintf("%s:%d", file, lineNo)
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") && !rangeRe.MatchString(line) {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			section, group = hostsSection, name
+			if g, kind, ok := strings.Cut(name, ":"); ok {
+				group = g
+				switch kind {
+				case "vars":
+					section = varsSection
+				case "children":
+					section = childrenSection
+				default:
+					return nil, fmt.Errorf("%s: unknown section type %q", where, kind)
+				}
+			}
+			if group == "" {
+				return nil, fmt.Errorf("%s: empty group name", where)
+			}
+			b.addGroup(group)
+			continue
+		}
+
+		switch section {
+		case varsSection:
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("%s: expected key=value, got %q", where, line)
+			}
+			b.setGroupVar(group, strings.TrimSpace(k), strings.TrimSpace(v))
+
+		case childrenSection:
+			b.addGroup(line)
+			b.children[group] = append(b.children[group], line)
+
+		default:
+			fields := strings.Fields(line)
+			e := entry{expr: fields[0], group: group, vars: make(map[string]string), where: where}
+			for _, f := range fields[1:] {
+				k, v, ok := strings.Cut(f, "=")
+				if !ok {
+					return nil, fmt.Errorf("%s: expected key=value, got %q", where, f)
+				}
+				e.vars[k] = v
+			}
+			b.entries = append(b.entries, e)
+		}
+	}
+
+	return b, scanner.Err()
+}
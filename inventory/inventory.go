@@ -0,0 +1,188 @@
+// Package inventory 主机清单: 支持INI/YAML文件, 主机范围/CIDR展开和idc数据库
+package inventory
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Host 单台主机及其变量, 未设置的变量沿用命令行的默认值
+type Host struct {
+	// 清单中的名称, 用于匹配
+	Name string
+	// 连接地址, 默认与Name相同
+	Addr string
+	Port int32
+	User string
+	// 私钥路径
+	Key string
+	// 跳板机, user@host:port, 多个用逗号分隔
+	Jump string
+
+	// 所属的组
+	Groups []string
+	// 其它变量, 可以作为标签匹配
+	Vars map[string]string
+}
+
+// Inventory 主机清单, 主机按首次出现的顺序排列
+type Inventory struct {
+	hosts  []*Host
+	byName map[string]*Host
+	groups map[string][]*Host
+}
+
+func newInventory() *Inventory {
+	return &Inventory{
+		byName: make(map[string]*Host),
+		groups: make(map[string][]*Host),
+	}
+}
+
+// Hosts 所有主机
+func (inv *Inventory) Hosts() []*Host {
+	return inv.hosts
+}
+
+// Groups 所有组名, 按名称排序
+func (inv *Inventory) Groups() []string {
+	names := make([]string, 0, len(inv.groups))
+	for name := range inv.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 添加主机到组, 同名主机合并变量
+func (inv *Inventory) add(name, group string, vars map[string]string) (*Host, error) {
+	h, ok := inv.byName[name]
+	if !ok {
+		h = &Host{Name: name, Addr: name, Vars: make(map[string]string)}
+		inv.byName[name] = h
+		inv.hosts = append(inv.hosts, h)
+	}
+
+	for k, v := range vars {
+		if err := h.set(k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if group != "" && !h.inGroup(group) {
+		h.Groups = append(h.Groups, group)
+		inv.groups[group] = append(inv.groups[group], h)
+	}
+	return h, nil
+}
+
+// 设置主机变量, 连接相关的变量写入对应字段, 其余作为标签
+func (h *Host) set(key, value string) error {
+	switch key {
+	case "host", "addr":
+		h.Addr = value
+	case "port":
+		p, err := strconv.Atoi(value)
+		if err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("inventory: host %s: invalid port %q", h.Name, value)
+		}
+		h.Port = int32(p)
+	case "user":
+		h.User = value
+	case "key":
+		h.Key = value
+	case "jump":
+		h.Jump = value
+	default:
+		h.Vars[key] = value
+	}
+	return nil
+}
+
+func (h *Host) inGroup(group string) bool {
+	for _, g := range h.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Select 按模式选择主机, 多个模式用逗号分隔, 结果为并集
+//
+//	web          组名
+//	10.0.0.*     主机名或地址的通配符匹配
+//	role=db      变量(标签)匹配, 值支持通配符
+//	!web         从结果中排除
+//
+// 模式为空或 all 时返回所有主机, 没有匹配的主机时返回错误
+func (inv *Inventory) Select(pattern string) ([]*Host, error) {
+	var include, exclude []string
+	for _, p := range strings.Split(pattern, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if strings.HasPrefix(p, "!") {
+			exclude = append(exclude, p[1:])
+		} else {
+			include = append(include, p)
+		}
+	}
+	if len(include) == 0 {
+		include = []string{"all"}
+	}
+
+	for _, p := range append(include, exclude...) {
+		glob := p
+		if _, v, ok := strings.Cut(p, "="); ok {
+			glob = v
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("inventory: invalid pattern %q", p)
+		}
+	}
+
+	var selected []*Host
+	for _, h := range inv.hosts {
+		if inv.match(h, include) && !inv.match(h, exclude) {
+			selected = append(selected, h)
+		}
+	}
+	// 模式写错时不应当作没有主机需要执行
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("inventory: 没有匹配 %q 的主机", pattern)
+	}
+	return selected, nil
+}
+
+func (inv *Inventory) match(h *Host, patterns []string) bool {
+	for _, p := range patterns {
+		if p == "all" {
+			return true
+		}
+
+		if k, v, ok := strings.Cut(p, "="); ok {
+			if val, exists := h.Vars[k]; exists {
+				if m, _ := path.Match(v, val); m {
+					return true
+				}
+			}
+			continue
+		}
+
+		if _, ok := inv.groups[p]; ok && h.inGroup(p) {
+			return true
+		}
+		if m, _ := path.Match(p, h.Name); m {
+			return true
+		}
+		if m, _ := path.Match(p, h.Addr); m {
+			return true
+		}
+	}
+	return false
+}
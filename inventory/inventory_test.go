@@ -0,0 +1,214 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	db "zeus/model"
+)
+
+func TestExpandHosts(t *testing.T) {
+	cases := []struct {
+		expr string
+		want []string
+	}{
+		{"10.0.0.1", []string{"10.0.0.1"}},
+		{"10.0.0.[1-3]", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"10.0.[1-2].[8-9]", []string{"10.0.1.8", "10.0.1.9", "10.0.2.8", "10.0.2.9"}},
+		{"web[08-10]", []string{"web08", "web09", "web10"}},
+		{"192.168.1.0/30", []string{"192.168.1.1", "192.168.1.2"}},
+		{"192.168.1.7/32", []string{"192.168.1.7"}},
+	}
+	for _, c := range cases {
+		got, err := ExpandHosts(c.expr)
+		if err != nil {
+			t.Errorf("ExpandHosts(%q): %v", c.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ExpandHosts(%q) = %v, want %v", c.expr, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "10.0.0.[5-1]", "10.0.0.0/8", "10.0.0.[1-x]", "300.0.0.0/24",
+		"10.0.0.[0-9223372036854775807]", "10.0.0.[1-99999999999999999999]", "10.0.[1-300].[1-300]"} {
+		if _, err := ExpandHosts(bad); err == nil {
+			t.Errorf("ExpandHosts(%q) should fail", bad)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func names(hosts []*Host) []string {
+	var n []string
+	for _, h := range hosts {
+		n = append(n, h.Name)
+	}
+	return n
+}
+
+func selectNames(t *testing.T, inv *Inventory, pattern string) []string {
+	t.Helper()
+
+	hosts, err := inv.Select(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names(hosts)
+}
+
+const iniInventoryFile = `
+# 不属于任何组
+10.0.9.1 port=2222
+
+[web]
+10.0.0.[1-3] user=deploy
+web-vip host=10.0.0.100 role=vip
+
+[web:vars]
+jump=root@10.0.255.1:22
+user=www
+
+[db]
+10.0.1.0/30 role=db
+
+[prod:children]
+web
+db
+
+[all:vars]
+user=root
+key=/root/.ssh/id_rsa
+`
+
+const yamlInventoryFile = `
+vars:
+  user: root
+  key: /root/.ssh/id_rsa
+hosts:
+  10.0.9.1:
+    port: 2222
+groups:
+  web:
+    vars:
+      jump: root@10.0.255.1:22
+      user: www
+    hosts:
+      10.0.0.[1-3]: {user: deploy}
+      web-vip: {host: 10.0.0.100, role: vip}
+  db:
+    hosts:
+      10.0.1.0/30: {role: db}
+  prod:
+    children: [web, db]
+`
+
+func TestLoad(t *testing.T) {
+	for name, data := range map[string]string{"hosts.ini": iniInventoryFile, "hosts.yaml": yamlInventoryFile} {
+		inv, err := Load(writeFile(t, name, data))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		all := []string{"10.0.9.1", "10.0.0.1", "10.0.0.2", "10.0.0.3", "web-vip", "10.0.1.1", "10.0.1.2"}
+		if got := names(inv.Hosts()); !reflect.DeepEqual(got, all) {
+			t.Errorf("%s: hosts = %v, want %v", name, got, all)
+		}
+
+		h := inv.byName["10.0.9.1"]
+		if h.Port != 2222 || h.User != "root" || h.Key != "/root/.ssh/id_rsa" || h.Jump != "" {
+			t.Errorf("%s: ungrouped host = %+v", name, h)
+		}
+
+		// 主机变量 > 组变量 > all
+		if h := inv.byName["10.0.0.2"]; h.User != "deploy" || h.Jump != "root@10.0.255.1:22" {
+			t.Errorf("%s: web host = %+v", name, h)
+		}
+		if h := inv.byName["web-vip"]; h.Addr != "10.0.0.100" || h.User != "www" || h.Vars["role"] != "vip" {
+			t.Errorf("%s: web-vip = %+v", name, h)
+		}
+
+		if got := selectNames(t, inv, "prod,!web"); !reflect.DeepEqual(got, []string{"10.0.1.1", "10.0.1.2"}) {
+			t.Errorf("%s: prod,!web = %v", name, got)
+		}
+		if got := selectNames(t, inv, "10.0.0.*"); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "web-vip"}) {
+			t.Errorf("%s: 10.0.0.* = %v", name, got)
+		}
+		if got := selectNames(t, inv, "role=v*,10.0.9.1"); !reflect.DeepEqual(got, []string{"10.0.9.1", "web-vip"}) {
+			t.Errorf("%s: role=v*,10.0.9.1 = %v", name, got)
+		}
+		if got := selectNames(t, inv, ""); len(got) != len(all) {
+			t.Errorf("%s: empty pattern selected %v", name, got)
+		}
+		// 写错的模式
+		if hosts, err := inv.Select("wbe"); err == nil {
+			t.Errorf("%s: wbe selected %v, want error", name, names(hosts))
+		}
+	}
+}
+
+func TestLoadDB(t *testing.T) {
+	cfg := db.DefaultConfig()
+	cfg.DSN = "sqlite::memory:"
+	store, err := db.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err := LoadDB(store); err == nil {
+		t.Fatal("LoadDB should fail before migrate")
+	}
+	if _, err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有资产信息的主机也能读取
+	base := db.Machine_Base_INFO_MODEL{SN: "7XK2N33", IP: "10.0.0.2", Model: "PowerEdge R740"}
+	if err := store.WriteToDB(db.Machine_INFO{Base: base}); err != nil {
+		t.Fatal(err)
+	}
+	inv, err := LoadDB(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inv.Select("model=PowerEdge*")
+	if err != nil || len(hosts) != 1 || hosts[0].Addr != "10.0.0.2" || hosts[0].Vars["sn"] != "7XK2N33" {
+		t.Fatalf("hosts = %v, err=%v", hosts, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := map[string]string{
+		"bad_port.ini":  "[web]\n10.0.0.1 port=http\n",
+		"bad_range.ini": "[web]\n10.0.0.[9-1]\n",
+		"bad_var.ini":   "[web:vars]\njump\n",
+		"unknown.yaml":  "groups: {}\nhostz: []\n",
+	}
+	for name, data := range cases {
+		if _, err := Load(writeFile(t, name, data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFromHosts(t *testing.T) {
+	inv, err := FromHosts([]string{"10.0.0.[1-2]", "10.0.0.2", "10.0.1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(inv.Hosts()); !reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2", "10.0.1.5"}) {
+		t.Fatalf("hosts = %v", got)
+	}
+}
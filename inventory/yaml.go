@@ -0,0 +1,129 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+YAML 格式(JSON 同样支持), 主机按文件中的顺序排列:
+
+	vars:
+	  user: root
+	hosts:
+	  10.0.9.1:
+	    port: 2222
+	groups:
+	  web:
+	    vars:
+	      jump: root@10.0.255.1:22
+	    hosts:
+	      10.0.0.[1-50]:
+	      web-vip: {host: 10.0.0.100, role: vip}
+	  db:
+	    hosts:
+	      10.0.1.0/28:
+	  prod:
+	    children: [web, db]
+*/
+
+type yamlGroup struct {
+	Vars     map[string]interface{} `yaml:"vars"`
+	Hosts    yaml.Node              `yaml:"hosts"`
+	Children []string               `yaml:"children"`
+}
+
+type yamlInventory struct {
+	Vars   map[string]interface{} `yaml:"vars"`
+	Hosts  yaml.Node              `yaml:"hosts"`
+	Groups yaml.Node              `yaml:"groups"`
+}
+
+func parseYAML(file string, data []byte) (*builder, error) {
+	b := newBuilder()
+
+	var inv yamlInventory
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&inv); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("inventory: %s: %w", file, err)
+	}
+
+	for k, v := range inv.Vars {
+		b.setGroupVar("all", k, fmt.Sprint(v))
+	}
+
+	if err := addYAMLHosts(b, file, "", &inv.Hosts); err != nil {
+		return nil, err
+	}
+
+	if inv.Groups.Kind != 0 && inv.Groups.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: groups must be a mapping", file, inv.Groups.Line)
+	}
+	for i := 0; i+1 < len(inv.Groups.Content); i += 2 {
+		name := inv.Groups.Content[i].Value
+
+		var g yamlGroup
+		if err := inv.Groups.Content[i+1].Decode(&g); err != nil {
+			return nil, fmt.Errorf("%s: group %q: %w", file, name, err)
+		}
+
+		b.addGroup(name)
+		for k, v := range g.Vars {
+			b.setGroupVar(name, k, fmt.Sprint(v))
+		}
+		for _, child := range g.Children {
+			b.addGroup(child)
+			b.children[name] = append(b.children[name], child)
+		}
+		if err := addYAMLHosts(b, file, name, &g.Hosts); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// hosts 可以是 主机 -> 变量 的映射, 也可以是主机列表
+func addYAMLHosts(b *builder, file, group string, n *yaml.Node) error {
+	switch n.Kind {
+	case 0:
+		return nil
+
+	case yaml.SequenceNode:
+		for _, h := range n.Content {
+			b.entries = append(b.entries, entry{
+				expr:  h.Value,
+				group: group,
+				where: fmt.Sprintf("%s:%d", file, h.Line),
+			})
+		}
+		return nil
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			e := entry{
+				expr:  key.Value,
+				group: group,
+				vars:  make(map[string]string),
+				where: fmt.Sprintf("%s:%d", file, key.Line),
+			}
+
+			vars := map[string]interface{}{}
+			if err := n.Content[i+1].Decode(&vars); err != nil {
+				return fmt.Errorf("%s: host vars must be a mapping: %w", e.where, err)
+			}
+			for k, v := range vars {
+				e.vars[k] = fmt.Sprint(v)
+			}
+			b.entries = append(b.entries, e)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s:%d: hosts must be a mapping or a list", file, n.Line)
+}
@@ -0,0 +1,180 @@
+package kwssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ParseAuthMethods 解析逗号分隔的认证方式列表, 按顺序尝试
+// 可选值: password, publickey, agent, keyboard-interactive
+func ParseAuthMethods(s string) ([]int, error) {
+	methods := make([]int, 0)
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "":
+			continue
+		case "password":
+			methods = append(methods, PASSWORD)
+		case "publickey", "key":
+			methods = append(methods, PUBLICKEY)
+		case "agent":
+			methods = append(methods, AGENT)
+		case "keyboard-interactive":
+			methods = append(methods, KEYBOARD_INTERACTIVE)
+		default:
+			return nil, fmt.Errorf("kwssh: unknown auth method %q", v)
+		}
+	}
+	return methods, nil
+}
+
+// IsKeyEncrypted 私钥是否有passphrase保护
+func IsKeyEncrypted(path string) (bool, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	return errors.As(err, &missing), nil
+}
+
+// 读取私钥, 有passphrase保护时使用 Task.Passphrase 解密
+func loadSigner(path, passphrase string) (ssh.Signer, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kwssh: ParsekeyPath err: %#v", err.Error())
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if passphrase == "" {
+			return nil, fmt.Errorf("kwssh: private key [%s] is encrypted, passphrase required", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kwssh: ParseKey [%s] err: %#v", path, err.Error())
+	}
+	return signer, nil
+}
+
+// 按 Task.AuthMethods 的顺序生成认证方式, 未指定时使用 Task.SSHType
+// 私钥和agent都属于publickey认证, ssh库对同一种认证只尝试一次, 所以合并为一个
+// 返回的 io.Closer 为agent连接, 握手结束后关闭
+func authMethods(target *Task) ([]ssh.AuthMethod, io.Closer, error) {
+	methods := target.AuthMethods
+	if len(methods) == 0 {
+		methods = []int{target.SSHType}
+	}
+
+	var (
+		auth      []ssh.AuthMethod
+		signers   []func() ([]ssh.Signer, error)
+		pubkeyPos = -1
+		agentConn net.Conn
+		// 不可用的认证方式, 没有任何可用方式时返回
+		skipped []string
+	)
+
+	addPublicKey := func(f func() ([]ssh.Signer, error)) {
+		if pubkeyPos < 0 {
+			pubkeyPos = len(auth)
+			auth = append(auth, nil)
+		}
+		signers = append(signers, f)
+	}
+
+	for _, m := range methods {
+		switch m {
+		case PUBLICKEY:
+			paths := append([]string{target.KeyPath}, target.KeyPaths...)
+			for _, p := range paths {
+				if p == "" {
+					continue
+				}
+				signer, err := loadSigner(p, target.Passphrase)
+				if err != nil {
+					closeConn(agentConn)
+					return nil, nil, err
+				}
+				addPublicKey(func() ([]ssh.Signer, error) {
+					return []ssh.Signer{signer}, nil
+				})
+			}
+
+		case AGENT:
+			if agentConn != nil {
+				continue
+			}
+			// agent 不可用时跳过, 继续尝试其它认证方式
+			sock := os.Getenv("SSH_AUTH_SOCK")
+			if sock == "" {
+				skipped = append(skipped, "agent: SSH_AUTH_SOCK is not set")
+				continue
+			}
+			conn, err := net.Dial("unix", sock)
+			if err != nil {
+				skipped = append(skipped, "agent: "+err.Error())
+				continue
+			}
+			agentConn = conn
+			addPublicKey(agent.NewClient(conn).Signers)
+
+		case PASSWORD:
+			auth = append(auth, ssh.Password(target.Pass))
+
+		case KEYBOARD_INTERACTIVE:
+			// 所有不回显的问题(一般是 Password:)都回答密码
+			pass := target.Pass
+			auth = append(auth, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range questions {
+					if !echos[i] {
+						answers[i] = pass
+					}
+				}
+				return answers, nil
+			}))
+		}
+	}
+
+	if pubkeyPos >= 0 {
+		auth[pubkeyPos] = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			all := make([]ssh.Signer, 0)
+			for _, f := range signers {
+				// agent 不可用时继续使用其它私钥
+				s, err := f()
+				if err != nil {
+					continue
+				}
+				all = append(all, s...)
+			}
+			return all, nil
+		})
+	}
+
+	if len(auth) == 0 {
+		return nil, nil, fmt.Errorf("kwssh: no auth method for [%s] %v", target.IP, skipped)
+	}
+
+	var closer io.Closer
+	if agentConn != nil {
+		closer = agentConn
+	}
+	return auth, closer, nil
+}
+
+func closeConn(c net.Conn) {
+	if c != nil {
+		c.Close()
+	}
+}
@@ -0,0 +1,150 @@
+package kwssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"zeus/kwssh/sshtest"
+)
+
+// 生成私钥文件, passphrase 不为空时加密
+func writeKey(t *testing.T, passphrase string) (string, ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, priv, sshPub
+}
+
+func TestParseAuthMethods(t *testing.T) {
+	got, err := ParseAuthMethods("agent, publickey,password,keyboard-interactive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{AGENT, PUBLICKEY, PASSWORD, KEYBOARD_INTERACTIVE}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ParseAuthMethods = %v, want %v", got, want)
+	}
+	if _, err := ParseAuthMethods("gssapi"); err == nil {
+		t.Fatal("unknown auth method should fail")
+	}
+}
+
+func TestEncryptedKey(t *testing.T) {
+	path, _, pub := writeKey(t, "s3cret")
+	s := newServer(t, sshtest.Config{
+		Users: map[string]sshtest.User{"root": {Keys: []ssh.PublicKey{pub}}},
+	})
+
+	if ok, err := IsKeyEncrypted(path); err != nil || !ok {
+		t.Fatalf("IsKeyEncrypted = %v, %v", ok, err)
+	}
+
+	task := testTask(s)
+	task.SSHType = PUBLICKEY
+	task.KeyPath = path
+
+	err := (&SSH{}).NewClient(context.Background(), &task)
+	if err == nil || !strings.Contains(err.Error(), "passphrase required") {
+		t.Fatalf("expected passphrase error, got %v", err)
+	}
+
+	task.Passphrase = "s3cret"
+	dial(t, task).client.Close()
+}
+
+func TestAgentAuth(t *testing.T) {
+	_, priv, pub := writeKey(t, "")
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix socket not available: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	s := newServer(t, sshtest.Config{
+		Users: map[string]sshtest.User{"root": {Keys: []ssh.PublicKey{pub}}},
+	})
+
+	task := testTask(s)
+	task.AuthMethods = []int{AGENT}
+	dial(t, task).client.Close()
+}
+
+func TestKeyboardInteractiveAuth(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Users: map[string]sshtest.User{"root": {Password: "secret", KeyboardInteractive: true}},
+	})
+
+	task := testTask(s)
+	if err := (&SSH{}).NewClient(context.Background(), &task); err == nil {
+		t.Fatal("password auth should be rejected")
+	}
+
+	task.AuthMethods = []int{KEYBOARD_INTERACTIVE}
+	dial(t, task).client.Close()
+}
+
+func TestAuthFallback(t *testing.T) {
+	wrongKey, _, _ := writeKey(t, "")
+	s := newServer(t, sshtest.Config{})
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	// agent 不可用, 私钥被拒绝, 最后使用密码登录
+	task := testTask(s)
+	task.KeyPath = wrongKey
+	task.AuthMethods = []int{AGENT, PUBLICKEY, PASSWORD}
+	dial(t, task).client.Close()
+
+	task.AuthMethods = []int{AGENT}
+	if err := (&SSH{}).NewClient(context.Background(), &task); err == nil || !strings.Contains(err.Error(), "SSH_AUTH_SOCK") {
+		t.Fatalf("expected agent error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package kwssh
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 服务器厂商, 由 dmidecode 的 Product Name 判断
+const (
+	VENDOR_DELL       = "dell"
+	VENDOR_HP         = "hp"
+	VENDOR_INSPUR     = "inspur"
+	VENDOR_HUAWEI     = "huawei"
+	VENDOR_SUPERMICRO = "supermicro"
+	// 无法识别的厂商, 只使用通用的采集方式
+	VENDOR_GENERIC = "generic"
+)
+
+var vendorPatterns = []struct {
+	vendor string
+	re     *regexp.Regexp
+}{
+	{VENDOR_DELL, regexp.MustCompile(`(?i)PowerEdge`)},
+	{VENDOR_HP, regexp.MustCompile(`(?i)ProLiant|Synergy|Apollo`)},
+	{VENDOR_INSPUR, regexp.MustCompile(`(?i)^(NF|SA|NE)\d{4}|Inspur`)},
+	{VENDOR_HUAWEI, regexp.MustCompile(`(?i)^\d{4}H V\d|^RH\d{4}|FusionServer|TaiShan|Huawei`)},
+	{VENDOR_SUPERMICRO, regexp.MustCompile(`(?i)^SYS-|^AS -|Super Server|Supermicro`)},
+}
+
+// VendorOf 根据 Product Name 判断服务器厂商
+func VendorOf(productName string) string {
+	productName = strings.TrimSpace(productName)
+	for _, v := range vendorPatterns {
+		if v.re.MatchString(productName) {
+			return v.vendor
+		}
+	}
+	return VENDOR_GENERIC
+}
+
+// 磁盘, RAID, 内存插槽和电源信息
+type hardware struct {
+	disks []Disk
+	raids []Raid
+	mems  []Memory
+	psus  []string
+}
+
+// 每一项都已采集到
+func (h hardware) complete() bool {
+	return len(h.disks) != 0 && len(h.raids) != 0 && len(h.mems) != 0 && len(h.psus) != 0
+}
+
+// 只补充还没有采集到的项
+func (h *hardware) merge(o hardware) {
+	if len(h.disks) == 0 {
+		h.disks = o.disks
+	}
+	if len(h.raids) == 0 {
+		h.raids = o.raids
+	}
+	if len(h.mems) == 0 {
+		h.mems = o.mems
+	}
+	if len(h.psus) == 0 {
+		h.psus = o.psus
+	}
+}
+
+// collector 通过某个管理工具采集硬件信息
+type collector interface {
+	// 需要执行的命令
+	commands() []string
+	// 解析命令的标准输出, outputs 与 commands 一一对应
+	parse(outputs []string) hardware
+}
+
+// 每个厂商依次尝试的collector, 前面的collector没有采集到的项由后面的补充
+var vendorCollectors = map[string][]collector{
+	VENDOR_DELL:       {omreportCollector{}, ipmitoolCollector{}, genericCollector{}},
+	VENDOR_HP:         {ssacliCollector{}, ipmitoolCollector{}, genericCollector{}},
+	VENDOR_INSPUR:     {storcliCollector{}, megacliCollector{}, ipmitoolCollector{}, genericCollector{}},
+	VENDOR_HUAWEI:     {storcliCollector{}, megacliCollector{}, ipmitoolCollector{}, genericCollector{}},
+	VENDOR_SUPERMICRO: {storcliCollector{}, megacliCollector{}, ipmitoolCollector{}, genericCollector{}},
+	VENDOR_GENERIC:    {genericCollector{}, ipmitoolCollector{}},
+}
+
+func collectorsFor(vendor string) []collector {
+	if c, ok := vendorCollectors[vendor]; ok {
+		return c
+	}
+	return vendorCollectors[VENDOR_GENERIC]
+}
+
+// 从命令结果中解析硬件信息, 按命令内容匹配, 与执行顺序无关
+func parseHardware(outs []CommandOutput) hardware {
+	stdout := make(map[string]string, len(outs))
+	for _, o := range outs {
+		stdout[o.Cmd] = string(o.Stdout)
+	}
+
+	var hw hardware
+	for _, c := range collectorsFor(VendorOf(stdout[productName])) {
+		cmds := c.commands()
+		outputs := make([]string, len(cmds))
+		ran := false
+		for i, cmd := range cmds {
+			if v, ok := stdout[cmd]; ok {
+				outputs[i] = v
+				ran = true
+			}
+		}
+		if ran {
+			hw.merge(c.parse(outputs))
+		}
+	}
+	return hw
+}
+
+func connLost(r CommandResult) bool {
+	return len(r.Res) != 0 && r.Res[len(r.Res)-1].ConnLost
+}
+
+func stdoutOf(r CommandResult, cmd string) string {
+	for _, o := range r.Res {
+		if o.Cmd == cmd {
+			return string(o.Stdout)
+		}
+	}
+	return ""
+}
+
+// Dell OpenManage
+type omreportCollector struct{}
+
+func (omreportCollector) commands() []string {
+	return []string{diskInfo, raidInfo, mems, pwrsupplies}
+}
+
+func (omreportCollector) parse(outputs []string) hardware {
+	return hardware{
+		disks: parseDiskInfo(outputs[0]),
+		raids: parseRaidInfo(outputs[1]),
+		mems:  parseMemInfo(outputs[2]),
+		psus:  parsePowerInfo(outputs[3]),
+	}
+}
@@ -0,0 +1,202 @@
+package kwssh
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeus/kwssh/sshtest"
+)
+
+func TestVendorOf(t *testing.T) {
+	cases := map[string]string{
+		" PowerEdge R740":      VENDOR_DELL,
+		"ProLiant DL380 Gen10": VENDOR_HP,
+		"NF5280M5":             VENDOR_INSPUR,
+		"2288H V5":             VENDOR_HUAWEI,
+		"RH2288H V3":           VENDOR_HUAWEI,
+		"SYS-6029P-TRT":        VENDOR_SUPERMICRO,
+		"Super Server":         VENDOR_SUPERMICRO,
+		"KVM":                  VENDOR_GENERIC,
+		"":                     VENDOR_GENERIC,
+	}
+	for product, want := range cases {
+		if got := VendorOf(product); got != want {
+			t.Errorf("VendorOf(%q) = %q, want %q", product, got, want)
+		}
+	}
+}
+
+func TestParseSsacli(t *testing.T) {
+	disks, raids := parseSsacli(readFixture(t, "ssacli_config_detail.txt"))
+
+	wantDisks := []Disk{
+		{Product: "EG000600JWJNP", Capacity: "600 GB", Media: "HDD", Serial: "WFK2B7Y10000K9264ZYN"},
+		{Product: "EG000600JWJNP", Capacity: "600 GB", Media: "HDD", Serial: "WFK2B8AZ0000K9264ZZ1"},
+		{Product: "MK001920GWXFK", Capacity: "1.9 TB", Media: "SSD", Serial: "19452A1B2C3D"},
+	}
+	if !reflect.DeepEqual(disks, wantDisks) {
+		t.Errorf("disks = %+v, want %+v", disks, wantDisks)
+	}
+
+	wantRaids := []Raid{
+		{Level: "RAID-1", Size: "558.88 GB"},
+		{Level: "RAID-10", Size: "1.75 TB"},
+	}
+	if !reflect.DeepEqual(raids, wantRaids) {
+		t.Errorf("raids = %+v, want %+v", raids, wantRaids)
+	}
+}
+
+func TestParseStorcli(t *testing.T) {
+	hw := storcliCollector{}.parse([]string{readFixture(t, "storcli_show.txt"), readFixture(t, "storcli_drives.txt")})
+	disks, raids := hw.disks, hw.raids
+
+	wantDisks := []Disk{
+		{Product: "ST600MM0088", Capacity: "558.375 GB", Media: "HDD", Serial: "W420J4XL"},
+		{Product: "ST600MM0088", Capacity: "558.375 GB", Media: "HDD", Serial: "W420K8PQ"},
+		{Product: "MZ7KH1T9HAJR0D3", Capacity: "1.745 TB", Media: "SSD", Serial: "S455NY0M612345"},
+	}
+	if !reflect.DeepEqual(disks, wantDisks) {
+		t.Errorf("disks = %+v, want %+v", disks, wantDisks)
+	}
+
+	wantRaids := []Raid{
+		{Level: "RAID-1", Size: "558.375 GB"},
+		{Level: "RAID-10", Size: "3.492 TB"},
+	}
+	if !reflect.DeepEqual(raids, wantRaids) {
+		t.Errorf("raids = %+v, want %+v", raids, wantRaids)
+	}
+}
+
+func TestParseMegacli(t *testing.T) {
+	raids := parseMegacliLD(readFixture(t, "megacli_ldinfo.txt"))
+	wantRaids := []Raid{
+		{Level: "RAID-1", Size: "558.375 GB"},
+		{Level: "RAID-10", Size: "3.492 TB"},
+		{Level: "RAID-5", Size: "7.276 TB"},
+	}
+	if !reflect.DeepEqual(raids, wantRaids) {
+		t.Errorf("raids = %+v, want %+v", raids, wantRaids)
+	}
+
+	disks := parseMegacliPD(readFixture(t, "megacli_pdlist.txt"))
+	wantDisks := []Disk{
+		{Product: "SEAGATE ST600MM0088 N004W420J4XL", Capacity: "558.911 GB", Media: "HDD", Serial: "5000C500A1B2C3D4"},
+		{Product: "S455NY0M612345 SAMSUNG MZ7LH1T9HMLT-00005 HXT7404Q", Capacity: "1.746 TB", Media: "SSD", Serial: "5002538E4012ABCD"},
+	}
+	if !reflect.DeepEqual(disks, wantDisks) {
+		t.Errorf("disks = %+v, want %+v", disks, wantDisks)
+	}
+}
+
+func TestParseIpmitoolPSU(t *testing.T) {
+	got := parseIpmitoolPSU(readFixture(t, "ipmitool_sdr_psu.txt"))
+	want := []string{"PS1 Status: Presence detected", "PS2 Status: Presence detected, Failure detected", "PS Redundancy: Fully Redundant"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseIpmitoolPSU = %q, want %q", got, want)
+	}
+}
+
+func TestParseGeneric(t *testing.T) {
+	hw := genericCollector{}.parse([]string{
+		readFixture(t, "lsblk.txt"),
+		readFixture(t, "smartctl_info.txt"),
+		readFixture(t, "dmidecode_memory.txt"),
+		readFixture(t, "dmidecode_power.txt"),
+	})
+
+	wantDisks := []Disk{
+		// 序列号来自smartctl
+		{Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420J4XL"},
+		// 型号和介质来自smartctl
+		{Product: "SAMSUNG MZ7KH1T9HAJR-00005", Capacity: "1787.88 GB", Media: "SSD", Serial: "S47PNA0M812345"},
+		{Product: "INTEL SSDPE2KX020T8", Capacity: "1863.02 GB", Media: "SSD", Serial: "PHLJ912345672P0BGN"},
+	}
+	if !reflect.DeepEqual(hw.disks, wantDisks) {
+		t.Errorf("disks = %+v, want %+v", hw.disks, wantDisks)
+	}
+
+	wantMems := []Memory{
+		{Location: "A1", Type: "DDR4 - Synchronous Registered (Buffered)", Size: "32768 MB"},
+		{Location: "B1", Type: "DDR4 - Synchronous", Size: "16384 MB"},
+	}
+	if !reflect.DeepEqual(hw.mems, wantMems) {
+		t.Errorf("mems = %+v, want %+v", hw.mems, wantMems)
+	}
+
+	if !reflect.DeepEqual(hw.psus, []string{"1200 W", "1200 W"}) {
+		t.Errorf("psus = %q", hw.psus)
+	}
+	if len(hw.raids) != 0 {
+		t.Errorf("generic collector should not report raids: %+v", hw.raids)
+	}
+}
+
+// HP机器没有安装ssacli时, 由后面的collector补充
+func TestFetchInfoCollectorFallback(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			productName:    {Stdout: " ProLiant DL380 Gen10\n"},
+			ssacliConfig:   {Stderr: "sh: ctrl: command not found\n", ExitCode: 127},
+			ipmitoolPSU:    {Stdout: readFixture(t, "ipmitool_sdr_psu.txt")},
+			lsblkDisks:     {Stdout: readFixture(t, "lsblk.txt")},
+			dmidecodeMem:   {Stdout: readFixture(t, "dmidecode_memory.txt")},
+			dmidecodePower: {Stdout: readFixture(t, "dmidecode_power.txt")},
+		},
+		Default: &sshtest.Response{},
+	})
+
+	var out bytes.Buffer
+	pb := newTestPlayBook(t, s, "fetch", 1)
+	pb.SetOutput(&out)
+	pb.FetchInfo(context.Background())
+
+	for _, want := range []string{
+		"[ProLiant DL380 Gen10]",
+		"磁盘: [ST600MM0088] 容量: [558.38 GB] 介质: [HDD]",
+		"内存位置: [A1] 内存类型: [DDR4 - Synchronous Registered (Buffered)] 内存容量: [32768 MB]",
+		"[PS1 Status: Presence detected; PS2 Status",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("FetchInfo output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	executed := strings.Join(s.Executed(), "\n")
+	for _, cmd := range []string{ssacliConfig, ipmitoolPSU, lsblkDisks} {
+		if !strings.Contains(executed, cmd) {
+			t.Errorf("command %q not executed", cmd)
+		}
+	}
+	// 不是Dell机器, 不执行omreport
+	if strings.Contains(executed, "omreport") {
+		t.Errorf("omreport executed on HP host:\n%s", executed)
+	}
+}
+
+// Dell机器omreport采集完整后不再执行其它collector
+func TestFetchInfoCollectorComplete(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			productName: {Stdout: " PowerEdge R740\n"},
+			diskInfo:    {Stdout: readFixture(t, "omreport_pdisk.txt")},
+			raidInfo:    {Stdout: readFixture(t, "omreport_vdisk.txt")},
+			mems:        {Stdout: readFixture(t, "omreport_memory.txt")},
+			pwrsupplies: {Stdout: " 750 W\n"},
+		},
+		Default: &sshtest.Response{},
+	})
+
+	pb := newTestPlayBook(t, s, "fetch", 1)
+	pb.FetchInfo(context.Background())
+
+	for _, cmd := range s.Executed() {
+		if cmd == ipmitoolPSU || cmd == lsblkDisks {
+			t.Fatalf("collector command %q executed after omreport was complete", cmd)
+		}
+	}
+}
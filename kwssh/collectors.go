@@ -0,0 +1,482 @@
+package kwssh
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// HP Smart Array, 新版本为ssacli, 旧版本为hpssacli
+	ssacliConfig = `$(command -v ssacli || command -v hpssacli) ctrl all show config detail`
+	// LSI/Broadcom MegaRAID, 浪潮, 华为, 超微等机型常用
+	storcliShow = `$(command -v storcli64 || command -v storcli || echo /opt/MegaRAID/storcli/storcli64) /call show`
+	// 每块硬盘的详细信息, 只从中读取序列号
+	storcliDrives = `$(command -v storcli64 || command -v storcli || echo /opt/MegaRAID/storcli/storcli64) /call/eall/sall show all`
+	megacliLD     = `$(command -v MegaCli64 || command -v MegaCli || echo /opt/MegaRAID/MegaCli/MegaCli64) -LDInfo -Lall -aALL -NoLog`
+	megacliPD     = `$(command -v MegaCli64 || command -v MegaCli || echo /opt/MegaRAID/MegaCli/MegaCli64) -PDList -aALL -NoLog`
+	ipmitoolPSU   = `ipmitool sdr type "Power Supply"`
+	// 不依赖厂商工具
+	lsblkDisks     = `lsblk -d -b -n -P -o NAME,SIZE,ROTA,TYPE,MODEL,SERIAL`
+	smartctlInfo   = `for d in $(lsblk -d -n -o NAME -e 1,7,11); do echo "=== /dev/$d"; smartctl -i /dev/$d; done`
+	dmidecodeMem   = `dmidecode -t 17`
+	dmidecodePower = `dmidecode -t 39`
+)
+
+// HP ssacli/hpssacli, 采集磁盘和RAID
+type ssacliCollector struct{}
+
+func (ssacliCollector) commands() []string { return []string{ssacliConfig} }
+
+func (ssacliCollector) parse(outputs []string) hardware {
+	disks, raids := parseSsacli(outputs[0])
+	return hardware{disks: disks, raids: raids}
+}
+
+// 解析 ssacli ctrl all show config detail
+// Logical Drive 和 physicaldrive 块内缩进更深的行属于该块
+func parseSsacli(data string) ([]Disk, []Raid) {
+	var disks []Disk
+	var raids []Raid
+
+	var disk Disk
+	var raid Raid
+	kind, indent := "", 0
+
+	flush := func() {
+		switch kind {
+		case "ld":
+			if raid.Size != "" {
+				raids = append(raids, raid)
+			}
+		case "pd":
+			if disk.Capacity != "" {
+				disks = append(disks, disk)
+			}
+		}
+		kind = ""
+	}
+
+	ldRe := regexp.MustCompile(`^Logical Drive: \d+$`)
+	for _, line := range strings.Split(data, "\n") {
+		text := strings.TrimSpace(line)
+		if text == "" {
+			continue
+		}
+		ind := len(line) - len(strings.TrimLeft(line, " \t"))
+		if kind != "" && ind <= indent {
+			flush()
+		}
+
+		switch {
+		case ldRe.MatchString(text):
+			kind, indent, raid = "ld", ind, Raid{}
+		case strings.HasPrefix(text, "physicaldrive "):
+			kind, indent, disk = "pd", ind, Disk{}
+		case kind != "":
+			key, val, ok := strings.Cut(text, ":")
+			if !ok {
+				continue
+			}
+			key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+			if kind == "ld" {
+				switch key {
+				case "Size":
+					raid.Size = val
+				case "Fault Tolerance":
+					raid.Level = "RAID-" + strings.ReplaceAll(val, "+", "")
+				}
+				continue
+			}
+
+			switch key {
+			case "Size":
+				disk.Capacity = val
+			case "Interface Type":
+				disk.Media = mediaOf(strings.Contains(val, "Solid State"))
+			case "Model":
+				// 厂商和型号之间有多个空格, 例如 HP      EG000600JWJNP
+				if f := strings.Fields(val); len(f) != 0 {
+					disk.Product = f[len(f)-1]
+				}
+			case "Serial Number":
+				disk.Serial = val
+			}
+		}
+	}
+	flush()
+
+	return disks, raids
+}
+
+// storcli, 采集磁盘和RAID
+type storcliCollector struct{}
+
+func (storcliCollector) commands() []string { return []string{storcliShow, storcliDrives} }
+
+func (storcliCollector) parse(outputs []string) hardware {
+	disks, raids := parseStorcli(outputs[0], parseStorcliSerials(outputs[1]))
+	return hardware{disks: disks, raids: raids}
+}
+
+var (
+	// DG/VD TYPE State Access Consist Cache Cac sCC Size Name
+	storcliVDRe = regexp.MustCompile(`^\d+/\d+\s+(RAID\d+)\s+(?:\S+\s+){6}([\d.]+ [KMGTP]B)`)
+	// EID:Slt DID State DG Size Intf Med SED PI SeSz Model Sp
+	storcliPDRe = regexp.MustCompile(`^(\d*:\d+)\s+\d+\s+\S+\s+\S+\s+([\d.]+ [KMGTP]B)\s+\S+\s+(\S+)\s+\S+\s+\S+\s+\S+\s+(\S+)`)
+	// Drive /c0/e252/s0 Device attributes :, 没有背板时为 /c0/s0
+	storcliDriveRe = regexp.MustCompile(`^Drive /c(\d+)(?:/e(\d+))?/s(\d+)\b`)
+)
+
+// 解析 storcli /call show 中的 VD LIST 和 PD LIST
+// serials 为 parseStorcliSerials 的结果, 按 控制器:EID:Slt 查找序列号
+func parseStorcli(data string, serials map[string]string) ([]Disk, []Raid) {
+	var disks []Disk
+	var raids []Raid
+
+	ctl := "0"
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if v, ok := strings.CutPrefix(line, "Controller = "); ok {
+			ctl = strings.TrimSpace(v)
+			continue
+		}
+		if m := storcliVDRe.FindStringSubmatch(line); m != nil {
+			raids = append(raids, Raid{
+				Level: strings.Replace(m[1], "RAID", "RAID-", 1),
+				Size:  m[2],
+			})
+			continue
+		}
+		if m := storcliPDRe.FindStringSubmatch(line); m != nil {
+			disks = append(disks, Disk{
+				Capacity: m[2],
+				Media:    m[3],
+				Product:  m[4],
+				Serial:   serials[ctl+":"+m[1]],
+			})
+		}
+	}
+
+	return disks, raids
+}
+
+// 解析 storcli /call/eall/sall show all 中每块硬盘的 SN, key 为 控制器:EID:Slt
+func parseStorcliSerials(data string) map[string]string {
+	serials := make(map[string]string)
+
+	drive := ""
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if m := storcliDriveRe.FindStringSubmatch(line); m != nil {
+			drive = m[1] + ":" + m[2] + ":" + m[3]
+			continue
+		}
+		if key, val, ok := strings.Cut(line, "="); ok && drive != "" && strings.TrimSpace(key) == "SN" {
+			serials[drive] = strings.TrimSpace(val)
+		}
+	}
+
+	return serials
+}
+
+// MegaCli, 没有安装storcli的老机器使用
+type megacliCollector struct{}
+
+func (megacliCollector) commands() []string { return []string{megacliLD, megacliPD} }
+
+func (megacliCollector) parse(outputs []string) hardware {
+	return hardware{
+		raids: parseMegacliLD(outputs[0]),
+		disks: parseMegacliPD(outputs[1]),
+	}
+}
+
+var megacliLevelRe = regexp.MustCompile(`Primary-(\d+), Secondary-(\d+)`)
+
+// 解析 MegaCli -LDInfo, Primary-1, Secondary-3 为 RAID-10
+func parseMegacliLD(data string) []Raid {
+	var raids []Raid
+	var raid *Raid
+
+	for _, line := range strings.Split(data, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		switch key {
+		case "Virtual Drive":
+			raids = append(raids, Raid{})
+			raid = &raids[len(raids)-1]
+		case "RAID Level":
+			if m := megacliLevelRe.FindStringSubmatch(val); m != nil && raid != nil {
+				raid.Level = "RAID-" + m[1]
+				if m[2] == "3" {
+					raid.Level += "0"
+				}
+			}
+		case "Size":
+			if raid != nil {
+				raid.Size = val
+			}
+		}
+	}
+
+	return raids
+}
+
+// 解析 MegaCli -PDList
+func parseMegacliPD(data string) []Disk {
+	var disks []Disk
+	var disk *Disk
+
+	for _, line := range strings.Split(data, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		switch key {
+		case "Enclosure Device ID":
+			disks = append(disks, Disk{})
+			disk = &disks[len(disks)-1]
+		case "Raw Size":
+			if disk != nil {
+				// 558.911 GB [0x45dd2fb0 Sectors]
+				disk.Capacity, _, _ = strings.Cut(val, " [")
+			}
+		case "WWN":
+			// Inquiry Data 中序列号的位置与接口类型有关, 使用每块硬盘唯一的WWN
+			if disk != nil {
+				disk.Serial = val
+			}
+		case "Inquiry Data":
+			// 厂商, 型号和序列号的顺序与接口类型有关, 原样保留
+			if disk != nil {
+				disk.Product = strings.Join(strings.Fields(val), " ")
+			}
+		case "Media Type":
+			if disk != nil {
+				disk.Media = mediaOf(val == "Solid State Device")
+			}
+		}
+	}
+
+	return disks
+}
+
+// ipmitool, 采集电源状态
+type ipmitoolCollector struct{}
+
+func (ipmitoolCollector) commands() []string { return []string{ipmitoolPSU} }
+
+func (ipmitoolCollector) parse(outputs []string) hardware {
+	return hardware{psus: parseIpmitoolPSU(outputs[0])}
+}
+
+// 解析 ipmitool sdr type "Power Supply", 例如 PS1 Status: Presence detected
+func parseIpmitoolPSU(data string) []string {
+	var psus []string
+	for _, line := range strings.Split(data, "\n") {
+		f := strings.Split(line, "|")
+		if len(f) < 5 {
+			continue
+		}
+		name, event := strings.TrimSpace(f[0]), strings.TrimSpace(f[4])
+		if name == "" || event == "" {
+			continue
+		}
+		psus = append(psus, name+": "+event)
+	}
+	return psus
+}
+
+// 通用方式, 使用lsblk, smartctl和dmidecode, 不依赖厂商工具, 采集不到RAID信息
+type genericCollector struct{}
+
+func (genericCollector) commands() []string {
+	return []string{lsblkDisks, smartctlInfo, dmidecodeMem, dmidecodePower}
+}
+
+func (genericCollector) parse(outputs []string) hardware {
+	return hardware{
+		disks: parseLsblk(outputs[0], parseSmartctl(outputs[1])),
+		mems:  parseDmidecodeMem(outputs[2]),
+		psus:  parseDmidecodePower(outputs[3]),
+	}
+}
+
+var lsblkPairRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// 解析 lsblk -P 的输出, 只保留磁盘
+// 型号为空或经过HBA后ROTA不准确时使用smartctl的信息
+func parseLsblk(data string, smart map[string]smartinfo) []Disk {
+	var disks []Disk
+
+	for _, line := range strings.Split(data, "\n") {
+		fields := map[string]string{}
+		for _, m := range lsblkPairRe.FindAllStringSubmatch(line, -1) {
+			fields[m[1]] = m[2]
+		}
+		if fields["TYPE"] != "disk" {
+			continue
+		}
+
+		disk := Disk{
+			Product: strings.TrimSpace(fields["MODEL"]),
+			Media:   mediaOf(fields["ROTA"] == "0"),
+			Serial:  strings.TrimSpace(fields["SERIAL"]),
+		}
+		if size, err := strconv.ParseFloat(fields["SIZE"], 64); err == nil {
+			disk.Capacity = fmt.Sprintf("%.2f GB", size/(1<<30))
+		}
+
+		if s, ok := smart[fields["NAME"]]; ok {
+			if disk.Product == "" {
+				disk.Product = s.model
+			}
+			if disk.Serial == "" {
+				disk.Serial = s.serial
+			}
+			if s.ssd {
+				disk.Media = mediaOf(true)
+			}
+		}
+		disks = append(disks, disk)
+	}
+
+	return disks
+}
+
+type smartinfo struct {
+	model  string
+	serial string
+	ssd    bool
+}
+
+// 解析多块磁盘的 smartctl -i 输出, 每块磁盘以 === /dev/<name> 开头
+func parseSmartctl(data string) map[string]smartinfo {
+	res := make(map[string]smartinfo)
+
+	name := ""
+	for _, line := range strings.Split(data, "\n") {
+		if dev, ok := strings.CutPrefix(line, "=== /dev/"); ok {
+			name = strings.TrimSpace(dev)
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		info := res[name]
+		switch key {
+		case "Device Model", "Model Number", "Product":
+			info.model = val
+		case "Serial Number", "Serial number":
+			info.serial = val
+		case "Rotation Rate":
+			info.ssd = strings.Contains(val, "Solid State")
+		}
+		res[name] = info
+	}
+
+	return res
+}
+
+// 按空行分隔dmidecode的输出, 返回标题为title的块中的字段
+func dmiBlocks(data, title string) []map[string]string {
+	var blocks []map[string]string
+	var cur map[string]string
+
+	for _, line := range strings.Split(data, "\n") {
+		text := strings.TrimSpace(line)
+		switch {
+		case text == "":
+			cur = nil
+		case text == title:
+			cur = map[string]string{}
+			blocks = append(blocks, cur)
+		case cur != nil:
+			if key, val, ok := strings.Cut(text, ":"); ok {
+				cur[strings.TrimSpace(key)] = strings.TrimSpace(val)
+			}
+		}
+	}
+
+	return blocks
+}
+
+// 解析 dmidecode -t 17, 跳过空插槽, 容量统一为MB
+func parseDmidecodeMem(data string) []Memory {
+	var memInfoList []Memory
+
+	for _, b := range dmiBlocks(data, "Memory Device") {
+		size := memSizeMB(b["Size"])
+		if size == "" {
+			continue
+		}
+
+		memType := b["Type"]
+		if d := b["Type Detail"]; d != "" && d != "None" {
+			memType += " - " + d
+		}
+		memInfoList = append(memInfoList, Memory{
+			Location: b["Locator"],
+			Type:     memType,
+			Size:     size,
+		})
+	}
+
+	return memInfoList
+}
+
+// 16 GB -> 16384 MB, 无法识别(例如 No Module Installed)时返回空
+func memSizeMB(s string) string {
+	f := strings.Fields(s)
+	if len(f) != 2 {
+		return ""
+	}
+	n, err := strconv.Atoi(f[0])
+	if err != nil || n == 0 {
+		return ""
+	}
+	switch f[1] {
+	case "MB":
+	case "GB":
+		n *= 1024
+	case "TB":
+		n *= 1024 * 1024
+	default:
+		return ""
+	}
+	return strconv.Itoa(n) + " MB"
+}
+
+// 解析 dmidecode -t 39 中每个电源的最大功率
+func parseDmidecodePower(data string) []string {
+	var psus []string
+	for _, b := range dmiBlocks(data, "System Power Supply") {
+		if v := b["Max Power Capacity"]; v != "" && v != "Unknown" {
+			psus = append(psus, v)
+		}
+	}
+	return psus
+}
+
+func mediaOf(ssd bool) string {
+	if ssd {
+		return "SSD"
+	}
+	return "HDD"
+}
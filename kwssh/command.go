@@ -2,77 +2,94 @@ package kwssh
 
 import (
 	"regexp"
+	"strconv"
 	"strings"
 )
 
-type machineDetail struct {
+// MachineDetail 一台机器的信息, 由 facts 和 collector 的采集结果生成
+// 标准输出, 数据库等输出都使用这个结构
+type MachineDetail struct {
+	IP string `json:"ip" yaml:"ip"`
 	// 服务器型号
-	productName string
+	ProductName string `json:"product_name" yaml:"product_name"`
+	// 服务器厂商, 由型号判断, 见 VendorOf
+	Vendor string `json:"vendor" yaml:"vendor"`
 	// 服务器序列号
-	sn string
-	// ip
-	ip string
+	SN string `json:"sn" yaml:"sn"`
 	// 操作系统
-	osName string
+	OSName string `json:"os_name" yaml:"os_name"`
 	// 内核版本
-	kernelVersion string
+	KernelVersion string `json:"kernel_version" yaml:"kernel_version"`
 
-	cpu struct {
-		fullName   string
-		cpuname    string
-		cpuCoreNum string
-	}
-	// 内存总量
-	memTotal string
+	// CPU型号 x 物理CPU数量
+	CPU     string `json:"cpu" yaml:"cpu"`
+	CPUName string `json:"cpu_name" yaml:"cpu_name"`
+	CPUNum  string `json:"cpu_num" yaml:"cpu_num"`
+	// 内存总量, 单位MB
+	MemTotal string `json:"mem_total_mb" yaml:"mem_total_mb"`
 
 	// 内存位置信息
-	mems []meminfo
+	Memorys []Memory `json:"memory" yaml:"memory"`
 	// 硬盘信息
-	hardDisks []diskinfo
-
+	Disks []Disk `json:"disks" yaml:"disks"`
 	// Raid 信息
-	raids []raidinfo
-
-	// 电源模块 信息
-	power string
+	Raids []Raid `json:"raids" yaml:"raids"`
+	// 每个电源模块的信息, 例如最大功率或状态
+	PSUs []string `json:"psus" yaml:"psus"`
+	// 电源模块信息, PSUs 以 "; " 连接
+	Power string `json:"-" yaml:"-"`
 }
 
-type diskinfo struct {
-
-	// 硬盘厂商
-	product string
+type Disk struct {
+	// 硬盘型号
+	Product string `json:"product" yaml:"product"`
 	// 硬盘容量
-	capacity string
-	// 磁盘介质
-	media string
+	Capacity string `json:"capacity" yaml:"capacity"`
+	// 磁盘介质, HDD 或 SSD
+	Media string `json:"media" yaml:"media"`
+	// 硬盘序列号, 同型号硬盘更换后只有序列号不同; 采集不到时为空
+	Serial string `json:"serial" yaml:"serial"`
 }
 
-type raidinfo struct {
-	// raid等级
-	raidLevel string
+type Raid struct {
+	// raid等级, 例如 RAID-1
+	Level string `json:"level" yaml:"level"`
 	// raid大小
-	size string
+	Size string `json:"size" yaml:"size"`
 }
 
-type meminfo struct {
-	location string
-	memType  string
-	size     string
+type Memory struct {
+	// 插槽位置
+	Location string `json:"location" yaml:"location"`
+	Type     string `json:"type" yaml:"type"`
+	Size     string `json:"size" yaml:"size"`
+}
+
+// 解析 omreport chassis pwrsupplies 中每个电源的最大功率
+func parsePowerInfo(data string) []string {
+	var psus []string
+	for _, v := range strings.Split(data, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			psus = append(psus, v)
+		}
+	}
+	return psus
 }
 
 // 硬盘信息解析函数
-func parseDiskInfo(data string) []diskinfo {
-	var hardDisks []diskinfo
+func parseDiskInfo(data string) []Disk {
+	var hardDisks []Disk
 
 	// 正则表达式匹配每个硬盘信息块
-	re := regexp.MustCompile(`(?s)Media\s+:\s+(?P<Media>\w+)\s+Capacity\s+:\s+(?P<Capacity>[\d,\.]+\s+GB)\s+\([\d]+\s+bytes\)\s+Product ID\s+:\s+(?P<Product>\w+)`)
+	re := regexp.MustCompile(`(?s)Media\s+:\s+(?P<Media>\w+)\s+Capacity\s+:\s+(?P<Capacity>[\d,\.]+\s+GB)\s+\([\d]+\s+bytes\)\s+Product ID\s+:\s+(?P<Product>\w+)(?:\s+Serial No\.\s+:\s+(?P<Serial>\S+))?`)
 	matches := re.FindAllStringSubmatch(data, -1)
 
 	for _, match := range matches {
-		hardDisks = append(hardDisks, diskinfo{
-			media:    match[1],
-			capacity: strings.ReplaceAll(match[2], ",", ""),
-			product:  match[3],
+		hardDisks = append(hardDisks, Disk{
+			Media:    match[1],
+			Capacity: strings.ReplaceAll(match[2], ",", ""),
+			Product:  match[3],
+			Serial:   match[4],
 		})
 	}
 
@@ -80,17 +97,17 @@ func parseDiskInfo(data string) []diskinfo {
 }
 
 // Raid信息解析函数
-func parseRaidInfo(data string) []raidinfo {
-	var raidInfos []raidinfo
+func parseRaidInfo(data string) []Raid {
+	var raidInfos []Raid
 
 	// 正则表达式匹配每个RAID信息块
 	re := regexp.MustCompile(`(?s)Layout\s+:\s+(?P<RaidLevel>RAID-\d+)\s+Size\s+:\s+(?P<Size>[\d,\.]+\s+GB)\s+\([\d]+\s+bytes\)`)
 	matches := re.FindAllStringSubmatch(data, -1)
 
 	for _, match := range matches {
-		raidInfos = append(raidInfos, raidinfo{
-			raidLevel: match[1],
-			size:      strings.ReplaceAll(match[2], ",", ""),
+		raidInfos = append(raidInfos, Raid{
+			Level: match[1],
+			Size:  strings.ReplaceAll(match[2], ",", ""),
 		})
 	}
 
@@ -98,10 +115,10 @@ func parseRaidInfo(data string) []raidinfo {
 }
 
 // 解析内存位置容量信息
-func parseMemInfo(data string) []meminfo {
+func parseMemInfo(data string) []Memory {
 
-	var memInfoList []meminfo = make([]meminfo, 0)
-	mem := new(meminfo)
+	var memInfoList []Memory = make([]Memory, 0)
+	mem := new(Memory)
 
 	for i, v := range strings.Split(data, "\n") {
 
@@ -110,15 +127,15 @@ func parseMemInfo(data string) []meminfo {
 		if i%3 == 0 {
 			if len(data) == 2 {
 				// fmt.Printf("localtion: %#v\n", data[1])
-				mem.location = strings.Trim(data[1], " ")
+				mem.Location = strings.Trim(data[1], " ")
 			}
 
 		}
 
 		if i%3 == 1 {
 			if len(data) == 2 {
-				mem.memType = strings.Trim(data[1], " ")
-				// fmt.Printf("memType: %#v\n", data[1])
+				mem.Type = strings.Trim(data[1], " ")
+				// fmt.Printf("Type: %#v\n", data[1])
 			}
 
 		}
@@ -126,13 +143,13 @@ func parseMemInfo(data string) []meminfo {
 		if i%3 == 2 {
 			if len(data) == 2 {
 				// fmt.Printf("Size: %#v\n", data[1])
-				mem.size = strings.Trim(data[1], " ")
+				mem.Size = strings.Trim(data[1], " ")
 
-				if mem.size != "" {
-					memInfoList = append(memInfoList, meminfo{
-						location: mem.location,
-						size:     mem.size,
-						memType:  mem.memType,
+				if mem.Size != "" {
+					memInfoList = append(memInfoList, Memory{
+						Location: mem.Location,
+						Size:     mem.Size,
+						Type:     mem.Type,
 					})
 				}
 			}
@@ -154,7 +171,81 @@ const (
 	productName   = `dmidecode -t1 |grep "Product Name" | awk -F: '{print $2}'`
 	memTotal      = `cat /proc/meminfo  | grep "MemTotal" | awk  '{print $2 }'`
 	raidInfo      = `omreport storage vdisk controller=0   | grep -E "Layout|^Size"`
-	diskInfo      = `omreport storage pdisk controller=0  | grep -E "Product ID|Capacity|Media"`
+	diskInfo      = `omreport storage pdisk controller=0  | grep -E "Product ID|Capacity|Media|Serial No"`
 	mems          = `omreport chassis memory  |grep -E "Connector Name|Type|Size"`
 	pwrsupplies   = `omreport chassis pwrsupplies |grep "Maximum Output Wattage" | awk -F: '{print $2}'`
 )
+
+// fact 一项机器基础信息: 名称, 采集命令, 以及把命令输出解析到 MachineDetail 对应字段的函数
+type fact struct {
+	name    string
+	command string
+	parse   func(out string, d *MachineDetail)
+}
+
+// 采集的机器基础信息, 按顺序执行
+// 磁盘, RAID, 内存插槽和电源信息由collector按厂商采集, 见 collector.go
+var facts = []fact{
+	{"product_name", productName, func(out string, d *MachineDetail) { d.ProductName = orDenied(trimOutput(out)) }},
+	{"sn", sn, func(out string, d *MachineDetail) { d.SN = orDenied(trimOutput(out)) }},
+	{"cpu_name", cpuName, func(out string, d *MachineDetail) { d.CPUName = trimOutput(out) }},
+	{"cpu_num", cpuCoreNum, func(out string, d *MachineDetail) { d.CPUNum = trimOutput(out) }},
+	{"mem_total", memTotal, func(out string, d *MachineDetail) { d.MemTotal = kbToMB(trimOutput(out)) }},
+	{"os_name", osName, func(out string, d *MachineDetail) { d.OSName = strings.Trim(strings.Trim(out, "\t"), "\n") }},
+	{"kernel_version", kernelVersion, func(out string, d *MachineDetail) { d.KernelVersion = trimOutput(out) }},
+}
+
+// 采集机器基础信息的命令
+var fetchCommands = factCommands()
+
+func factCommands() []string {
+	cmds := make([]string, 0, len(facts))
+	for _, f := range facts {
+		cmds = append(cmds, f.command)
+	}
+	return cmds
+}
+
+// ParseMachineDetail 从采集命令的结果生成机器信息, 按命令内容匹配, 与执行顺序无关
+func ParseMachineDetail(res CommandResult) MachineDetail {
+	d := MachineDetail{IP: res.IP}
+
+	stdout := make(map[string]string, len(res.Res))
+	for _, o := range res.Res {
+		stdout[o.Cmd] = string(o.Stdout)
+	}
+	for _, f := range facts {
+		f.parse(stdout[f.command], &d)
+	}
+
+	d.Vendor = VendorOf(stdout[productName])
+	d.CPU = d.CPUName + " x " + d.CPUNum
+
+	hw := parseHardware(res.Res)
+	d.Disks, d.Raids, d.Memorys, d.PSUs = hw.disks, hw.raids, hw.mems, hw.psus
+	d.Power = strings.Join(hw.psus, "; ")
+
+	return d
+}
+
+// 去掉命令输出开头的空格和首尾的换行
+func trimOutput(out string) string {
+	return strings.Trim(strings.TrimLeft(out, " "), "\n")
+}
+
+// 普通用户执行dmidecode没有输出
+func orDenied(s string) string {
+	if len(s) == 0 {
+		return "无权限查看"
+	}
+	return s
+}
+
+// /proc/meminfo 中的kB转换为MB
+func kbToMB(s string) string {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return s
+	}
+	return strconv.Itoa(int(f / 1024))
+}
@@ -0,0 +1,123 @@
+package kwssh
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseDiskInfo(t *testing.T) {
+	got := parseDiskInfo(readFixture(t, "omreport_pdisk.txt"))
+	want := []Disk{
+		{Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420J4XL"},
+		{Product: "MZ7KH1T9HAJR0D3", Capacity: "1787.88 GB", Media: "SSD", Serial: "S47PNA0M812345"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseDiskInfo = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRaidInfo(t *testing.T) {
+	got := parseRaidInfo(readFixture(t, "omreport_vdisk.txt"))
+	want := []Raid{
+		{Level: "RAID-1", Size: "558.38 GB"},
+		{Level: "RAID-5", Size: "3575.75 GB"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseRaidInfo = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMemInfo(t *testing.T) {
+	got := parseMemInfo(readFixture(t, "omreport_memory.txt"))
+	want := []Memory{
+		{Location: "A1", Type: "DDR4 - Synchronous Registered (Buffered)", Size: "16384 MB"},
+		{Location: "A2", Type: "DDR4 - Synchronous Registered (Buffered)", Size: "16384 MB"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseMemInfo = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseMachineDetail(t *testing.T) {
+	outputs := map[string]string{
+		productName:   " PowerEdge R740\n",
+		sn:            "",
+		cpuName:       " Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz\n",
+		cpuCoreNum:    "2\n",
+		memTotal:      "32768000\n",
+		osName:        "CentOS Linux 7 (Core)\n",
+		kernelVersion: "3.10.0-1160.el7.x86_64\n",
+		diskInfo:      readFixture(t, "omreport_pdisk.txt"),
+		raidInfo:      readFixture(t, "omreport_vdisk.txt"),
+		mems:          readFixture(t, "omreport_memory.txt"),
+		pwrsupplies:   " 750 W\n 750 W\n",
+	}
+
+	// 结果的顺序与执行顺序无关
+	res := CommandResult{IP: "10.0.0.1"}
+	cmds := append(factCommands(), omreportCollector{}.commands()...)
+	for i := len(cmds) - 1; i >= 0; i-- {
+		res.Res = append(res.Res, CommandOutput{Cmd: cmds[i], Stdout: []byte(outputs[cmds[i]])})
+	}
+
+	d := ParseMachineDetail(res)
+	want := MachineDetail{
+		IP:            "10.0.0.1",
+		ProductName:   "PowerEdge R740",
+		Vendor:        VENDOR_DELL,
+		SN:            "无权限查看",
+		OSName:        "CentOS Linux 7 (Core)",
+		KernelVersion: "3.10.0-1160.el7.x86_64",
+		CPU:           "Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz x 2",
+		CPUName:       "Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz",
+		CPUNum:        "2",
+		MemTotal:      "32000",
+		Memorys:       parseMemInfo(outputs[mems]),
+		Disks:         parseDiskInfo(outputs[diskInfo]),
+		Raids:         parseRaidInfo(outputs[raidInfo]),
+		PSUs:          []string{"750 W", "750 W"},
+		Power:         "750 W; 750 W",
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("ParseMachineDetail =\n%+v\nwant\n%+v", d, want)
+	}
+}
+
+// 使用真实格式的 /proc/meminfo 执行采集命令, 而不是按命令查找模拟的输出
+func TestMemTotalCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+
+	fixture, err := filepath.Abs(filepath.Join("testdata", "proc_meminfo.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := strings.Replace(memTotal, "/proc/meminfo", fixture, 1)
+	if !strings.Contains(cmd, fixture) {
+		t.Fatalf("memTotal %q does not read /proc/meminfo", memTotal)
+	}
+	out, err := exec.Command("sh", "-c", cmd).Output()
+	if err != nil {
+		t.Fatalf("%s: %v", cmd, err)
+	}
+
+	d := ParseMachineDetail(CommandResult{Res: []CommandOutput{{Cmd: memTotal, Stdout: out}}})
+	if d.MemTotal != "257413" {
+		t.Errorf("MemTotal = %q from %q, want 257413", d.MemTotal, out)
+	}
+}
@@ -0,0 +1,415 @@
+package kwssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// 文件传输方向
+const (
+	_ = iota
+	// 本地文件或目录上传到远程
+	UPLOAD
+	// 远程文件或目录下载到本地, 每台主机一个目录
+	DOWNLOAD
+)
+
+// CopyStep 文件传输, 在Task的命令之前执行
+type CopyStep struct {
+	Direction int
+	// 上传时为本地路径, 下载时为远程路径
+	Src string
+	// 上传时为远程路径, 以/结尾或是已存在的目录时放到该目录下
+	// 下载时为本地目录, 文件保存到 Dest/<IP>/ 下
+	Dest string
+	// 上传后的权限, 为0时与本地文件相同
+	Mode os.FileMode
+	// 上传后的属主, 例如 root:root, 为空时不修改
+	Owner string
+}
+
+// CopyOutput 单个文件传输步骤的结果
+type CopyOutput struct {
+	Direction int
+	Src       string
+	// 实际保存的路径
+	Dest string
+	// 传输的文件数量和字节数
+	Files int
+	Bytes int64
+	// 内容相同跳过的文件数量
+	Skipped int
+	Err     error
+
+	Duration time.Duration
+}
+
+func (o CopyOutput) OK() bool {
+	return o.Err == nil
+}
+
+// ParseCopy 解析 src:dest 格式的参数
+func ParseCopy(direction int, s string) (CopyStep, error) {
+	src, dest, ok := strings.Cut(s, ":")
+	if !ok || src == "" || dest == "" {
+		return CopyStep{}, fmt.Errorf("kwssh: 文件传输参数格式错误 %q, 应为 src:dest", s)
+	}
+	return CopyStep{Direction: direction, Src: src, Dest: dest}, nil
+}
+
+// Copy 通过sftp依次执行文件传输, 某一步失败后不再执行后面的步骤
+func (s *SSH) Copy(ctx context.Context, steps []CopyStep) []CopyOutput {
+	outs := make([]CopyOutput, 0, len(steps))
+
+	client, err := sftp.NewClient(s.client)
+	if err != nil {
+		for _, step := range steps {
+			outs = append(outs, CopyOutput{Direction: step.Direction, Src: step.Src, Dest: step.Dest,
+				Err: fmt.Errorf("kwssh: start sftp failed, err=%#v", err.Error())})
+		}
+		return outs
+	}
+	defer client.Close()
+
+	// ctx 取消时关闭sftp, 中断正在进行的传输
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	failed := false
+	for _, step := range steps {
+		out := CopyOutput{Direction: step.Direction, Src: step.Src, Dest: step.Dest}
+		switch {
+		case failed:
+			out.Err = fmt.Errorf("kwssh: 前面的文件传输失败, 未执行")
+		case ctx.Err() != nil:
+			out.Err = ctx.Err()
+		default:
+			start := time.Now()
+			if step.Direction == DOWNLOAD {
+				out.Err = s.download(ctx, client, step, &out)
+			} else {
+				out.Err = s.upload(ctx, client, step, &out)
+			}
+			out.Duration = time.Since(start)
+			if out.Err != nil && ctx.Err() != nil {
+				out.Err = ctx.Err()
+			}
+		}
+
+		failed = failed || out.Err != nil
+		outs = append(outs, out)
+	}
+	return outs
+}
+
+func (s *SSH) upload(ctx context.Context, c *sftp.Client, step CopyStep, out *CopyOutput) error {
+	fi, err := os.Stat(step.Src)
+	if err != nil {
+		return err
+	}
+
+	dest := step.Dest
+	if rfi, err := c.Stat(dest); strings.HasSuffix(dest, "/") || (err == nil && rfi.IsDir()) {
+		dest = path.Join(dest, filepath.Base(step.Src))
+	}
+	out.Dest = dest
+
+	if !fi.IsDir() {
+		if err := c.MkdirAll(path.Dir(dest)); err != nil {
+			return fmt.Errorf("kwssh: mkdir [%s] err: %w", path.Dir(dest), err)
+		}
+		if err := s.uploadFile(ctx, c, step.Src, dest, fileMode(step.Mode, fi), out); err != nil {
+			return err
+		}
+	} else {
+		err = filepath.WalkDir(step.Src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(step.Src, p)
+			remote := path.Join(dest, filepath.ToSlash(rel))
+
+			if d.IsDir() {
+				if err := c.MkdirAll(remote); err != nil {
+					return fmt.Errorf("kwssh: mkdir [%s] err: %w", remote, err)
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				// 不传输符号链接等特殊文件
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			return s.uploadFile(ctx, c, p, remote, fileMode(step.Mode, info), out)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if step.Owner != "" {
+		cmd := "chown -R " + shellQuote(step.Owner) + " -- " + shellQuote(dest)
+		if o := runCommand(ctx, s.client, cmd); !o.OK() {
+			return fmt.Errorf("kwssh: chown [%s] failed: %s %s", dest, o.Status(), strings.TrimSpace(string(o.Stderr)))
+		}
+	}
+	return nil
+}
+
+// 上传单个文件, 远程文件内容相同时只修改权限
+// 先写入临时文件, 校验后再改名, 避免留下不完整的文件
+func (s *SSH) uploadFile(ctx context.Context, c *sftp.Client, local, remote string, mode os.FileMode, out *CopyOutput) error {
+	localSum, err := localChecksum(local)
+	if err != nil {
+		return err
+	}
+	remoteSum, err := s.remoteChecksum(ctx, c, remote)
+	if err != nil {
+		return err
+	}
+	if remoteSum == localSum {
+		out.Skipped++
+		return c.Chmod(remote, mode)
+	}
+
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := remote + ".kwssh-tmp"
+	dst, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return fmt.Errorf("kwssh: create [%s] err: %w", tmp, err)
+	}
+	n, err := io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		c.Remove(tmp)
+		return fmt.Errorf("kwssh: upload [%s] err: %w", remote, err)
+	}
+
+	if sum, err := s.remoteChecksum(ctx, c, tmp); err != nil || sum != localSum {
+		c.Remove(tmp)
+		return fmt.Errorf("kwssh: upload [%s] checksum mismatch", remote)
+	}
+	if err := c.Chmod(tmp, mode); err != nil {
+		c.Remove(tmp)
+		return err
+	}
+	if err := c.PosixRename(tmp, remote); err != nil {
+		c.Remove(tmp)
+		return fmt.Errorf("kwssh: rename [%s] err: %w", remote, err)
+	}
+
+	out.Files++
+	out.Bytes += n
+	return nil
+}
+
+func (s *SSH) download(ctx context.Context, c *sftp.Client, step CopyStep, out *CopyOutput) error {
+	fi, err := c.Stat(step.Src)
+	if err != nil {
+		return fmt.Errorf("kwssh: stat [%s] err: %w", step.Src, err)
+	}
+
+	base := filepath.Join(step.Dest, s.ip)
+	if err := os.MkdirAll(base, 0755); err != nil {
+		return err
+	}
+	root, err := localPath(base, filepath.Join(base, path.Base(step.Src)))
+	if err != nil {
+		return err
+	}
+	out.Dest = root
+
+	if !fi.IsDir() {
+		return s.downloadFile(ctx, c, step.Src, root, fileMode(step.Mode, fi), out)
+	}
+
+	src := path.Clean(step.Src)
+	walker := c.Walk(src)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), src), "/")
+		local, err := localPath(base, filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+
+		info := walker.Stat()
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := s.downloadFile(ctx, c, walker.Path(), local, fileMode(step.Mode, info), out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 检查下载到本地的路径在 base (Dest/<IP>) 下
+// 远程路径中的 .. 和本地已有的指向其它目录的符号链接都可能写到 base 之外
+func localPath(base, p string) (string, error) {
+	p = filepath.Clean(p)
+	if !withinDir(base, p) {
+		return "", fmt.Errorf("kwssh: 下载路径 [%s] 不在 [%s] 下", p, base)
+	}
+
+	realBase, err := filepath.EvalSymlinks(base)
+	if err != nil {
+		return "", err
+	}
+	// 已存在的最深一级路径, 解析符号链接后仍需在 base 下
+	existing := p
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(realBase, real) {
+		return "", fmt.Errorf("kwssh: 下载路径 [%s] 经符号链接指向 [%s], 不在 [%s] 下", p, real, base)
+	}
+	return p, nil
+}
+
+// p 是否为 dir 或在 dir 下, 两者都是 Clean 过的路径
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 下载单个文件, 本地文件内容相同时跳过
+func (s *SSH) downloadFile(ctx context.Context, c *sftp.Client, remote, local string, mode os.FileMode, out *CopyOutput) error {
+	remoteSum, err := s.remoteChecksum(ctx, c, remote)
+	if err != nil {
+		return err
+	}
+	if sum, err := localChecksum(local); err == nil && sum == remoteSum {
+		out.Skipped++
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+
+	src, err := c.Open(remote)
+	if err != nil {
+		return fmt.Errorf("kwssh: open [%s] err: %w", remote, err)
+	}
+	defer src.Close()
+
+	tmp := local + ".kwssh-tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(dst, h), src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("kwssh: download [%s] err: %w", remote, err)
+	}
+
+	// 下载过程中远程文件被修改
+	if hex.EncodeToString(h.Sum(nil)) != remoteSum {
+		os.Remove(tmp)
+		return fmt.Errorf("kwssh: download [%s] checksum mismatch", remote)
+	}
+	if err := os.Rename(tmp, local); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	out.Files++
+	out.Bytes += n
+	return nil
+}
+
+// 远程文件的sha256, 文件不存在时返回空
+// 优先使用远程的sha256sum命令, 没有时通过sftp读取文件计算
+func (s *SSH) remoteChecksum(ctx context.Context, c *sftp.Client, p string) (string, error) {
+	if _, err := c.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("kwssh: stat [%s] err: %w", p, err)
+	}
+
+	o := runCommand(ctx, s.client, "sha256sum -- "+shellQuote(p))
+	if o.OK() {
+		if f := strings.Fields(string(o.Stdout)); len(f) > 0 && len(f[0]) == sha256.Size*2 {
+			return f[0], nil
+		}
+	}
+
+	f, err := c.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("kwssh: open [%s] err: %w", p, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("kwssh: read [%s] err: %w", p, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func localChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileMode(mode os.FileMode, fi os.FileInfo) os.FileMode {
+	if mode != 0 {
+		return mode
+	}
+	return fi.Mode().Perm()
+}
+
+// 单引号包裹, 用于拼接shell命令
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
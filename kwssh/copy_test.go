@@ -0,0 +1,196 @@
+package kwssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zeus/kwssh/sshtest"
+)
+
+func TestParseCopy(t *testing.T) {
+	step, err := ParseCopy(UPLOAD, "./deploy.sh:/tmp/")
+	if err != nil || step.Src != "./deploy.sh" || step.Dest != "/tmp/" || step.Direction != UPLOAD {
+		t.Fatalf("ParseCopy = %+v, %v", step, err)
+	}
+	for _, s := range []string{"", "a", ":b", "a:"} {
+		if _, err := ParseCopy(DOWNLOAD, s); err == nil {
+			t.Errorf("ParseCopy(%q) should fail", s)
+		}
+	}
+}
+
+func TestCopyUpload(t *testing.T) {
+	s := newServer(t, sshtest.Config{SFTP: true})
+
+	local, remote := t.TempDir(), t.TempDir()
+	src := filepath.Join(local, "deploy.sh")
+	os.WriteFile(src, []byte("echo hello\n"), 0644)
+
+	steps := []CopyStep{{Direction: UPLOAD, Src: src, Dest: remote + "/", Mode: 0755}}
+	copyOnce := func() CopyOutput {
+		cli := dial(t, testTask(s))
+		defer cli.Close()
+		outs := cli.Copy(context.Background(), steps)
+		if len(outs) != 1 || !outs[0].OK() {
+			t.Fatalf("Copy = %+v", outs)
+		}
+		return outs[0]
+	}
+
+	out := copyOnce()
+	dest := filepath.Join(remote, "deploy.sh")
+	if out.Dest != dest || out.Files != 1 || out.Bytes != 11 {
+		t.Fatalf("first upload = %+v", out)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "echo hello\n" {
+		t.Fatalf("remote content = %q", b)
+	}
+	if fi, _ := os.Stat(dest); fi.Mode().Perm() != 0755 {
+		t.Fatalf("remote mode = %v, want 0755", fi.Mode().Perm())
+	}
+
+	// 内容未变化时跳过
+	if out := copyOnce(); out.Files != 0 || out.Skipped != 1 {
+		t.Fatalf("second upload = %+v, want skipped", out)
+	}
+
+	os.WriteFile(src, []byte("echo world\n"), 0644)
+	if out := copyOnce(); out.Files != 1 {
+		t.Fatalf("changed upload = %+v", out)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "echo world\n" {
+		t.Fatalf("remote content = %q", b)
+	}
+
+	// 没有残留的临时文件
+	entries, _ := os.ReadDir(remote)
+	if len(entries) != 1 {
+		t.Fatalf("remote dir has %d entries, want 1", len(entries))
+	}
+}
+
+func TestCopyUploadDirOwner(t *testing.T) {
+	s := newServer(t, sshtest.Config{SFTP: true})
+
+	local, remote := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(local, "conf", "sub"), 0755)
+	os.WriteFile(filepath.Join(local, "conf", "a.conf"), []byte("a"), 0600)
+	os.WriteFile(filepath.Join(local, "conf", "sub", "b.conf"), []byte("bb"), 0644)
+
+	dest := filepath.Join(remote, "etc")
+	chown := "chown -R 'app:app' -- '" + dest + "'"
+	s.SetResponse(chown, sshtest.Response{})
+
+	cli := dial(t, testTask(s))
+	defer cli.Close()
+	outs := cli.Copy(context.Background(), []CopyStep{{Direction: UPLOAD, Src: filepath.Join(local, "conf"), Dest: dest, Owner: "app:app"}})
+	if !outs[0].OK() || outs[0].Files != 2 || outs[0].Bytes != 3 {
+		t.Fatalf("Copy = %+v", outs)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dest, "sub", "b.conf")); string(b) != "bb" {
+		t.Fatalf("b.conf = %q", b)
+	}
+	if fi, _ := os.Stat(filepath.Join(dest, "a.conf")); fi.Mode().Perm() != 0600 {
+		t.Fatalf("a.conf mode = %v, want local mode 0600", fi.Mode().Perm())
+	}
+
+	executed := strings.Join(s.Executed(), "\n")
+	if !strings.Contains(executed, chown) {
+		t.Fatalf("chown not executed, executed:\n%s", executed)
+	}
+}
+
+func TestCopyDownload(t *testing.T) {
+	s := newServer(t, sshtest.Config{SFTP: true})
+
+	remote, local := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(remote, "log", "old"), 0755)
+	os.WriteFile(filepath.Join(remote, "log", "app.log"), []byte("line1\n"), 0644)
+	os.WriteFile(filepath.Join(remote, "log", "old", "app.log.1"), []byte("line0\n"), 0644)
+
+	pb := New("download", 1)
+	pb.SetOutput(&strings.Builder{})
+	task := testTask(s)
+	task.Copies = []CopyStep{{Direction: DOWNLOAD, Src: filepath.Join(remote, "log"), Dest: local}}
+	pb.AddTask("download", task)
+
+	for i, wantFiles := range []int{2, 0} {
+		for res := range pb.Exec(context.Background()) {
+			if !res.OK() || len(res.Res) != 0 || len(res.Copies) != 1 {
+				t.Fatalf("run %d: result = %+v, %s", i, res, failReason(res))
+			}
+			if res.Copies[0].Files != wantFiles {
+				t.Fatalf("run %d: downloaded %d files, want %d", i, res.Copies[0].Files, wantFiles)
+			}
+		}
+	}
+
+	b, _ := os.ReadFile(filepath.Join(local, s.Host(), "log", "old", "app.log.1"))
+	if string(b) != "line0\n" {
+		t.Fatalf("downloaded content = %q", b)
+	}
+}
+
+func TestCopyDownloadOutsideDest(t *testing.T) {
+	s := newServer(t, sshtest.Config{SFTP: true})
+
+	remote, local, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(remote, "log", "old"), 0755)
+	os.WriteFile(filepath.Join(remote, "log", "old", "app.log.1"), []byte("line0\n"), 0644)
+
+	// 本地已有指向其它目录的符号链接
+	os.MkdirAll(filepath.Join(local, s.Host(), "log"), 0755)
+	os.Symlink(outside, filepath.Join(local, s.Host(), "log", "old"))
+
+	for _, src := range []string{filepath.Join(remote, "log"), filepath.Join(remote, "log") + "/.."} {
+		pb := New("download", 1)
+		pb.SetOutput(&strings.Builder{})
+		task := testTask(s)
+		task.Copies = []CopyStep{{Direction: DOWNLOAD, Src: src, Dest: local}}
+		pb.AddTask("download", task)
+
+		for res := range pb.Exec(context.Background()) {
+			if res.OK() || len(res.Copies) != 1 || !strings.Contains(res.Copies[0].Err.Error(), "不在") {
+				t.Fatalf("download %s: result = %+v, want path error", src, res.Copies)
+			}
+		}
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("files written outside dest: %v", entries)
+	}
+	if entries, _ := os.ReadDir(local); len(entries) != 1 {
+		t.Errorf("files written outside dest/<IP>: %v", entries)
+	}
+}
+
+func TestCopyFailureSkipsCommands(t *testing.T) {
+	s := newServer(t, sshtest.Config{SFTP: true, Commands: map[string]sshtest.Response{"echo": {Stdout: "x"}}})
+
+	pb := New("copyfail", 1)
+	pb.SetOutput(&strings.Builder{})
+	task := testTask(s, "echo")
+	task.Copies = []CopyStep{
+		{Direction: UPLOAD, Src: filepath.Join(t.TempDir(), "missing"), Dest: "/tmp/"},
+		{Direction: UPLOAD, Src: "/etc/hostname", Dest: t.TempDir()},
+	}
+	pb.AddTask("copyfail", task)
+
+	for res := range pb.Exec(context.Background()) {
+		if res.OK() || len(res.Res) != 0 {
+			t.Fatalf("result = %+v, want copy failure without commands", res)
+		}
+		if len(res.Copies) != 2 || res.Copies[1].Err == nil {
+			t.Fatalf("second copy should not run: %+v", res.Copies)
+		}
+	}
+	for _, cmd := range s.Executed() {
+		if cmd == "echo" {
+			t.Fatal("command executed after copy failure")
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package kwssh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	db "zeus/model"
+)
+
+// 单台主机的对比状态
+const (
+	DIFF_UNCHANGED = "unchanged"
+	DIFF_CHANGED   = "changed"
+	// 数据库中没有该sn
+	DIFF_NEW    = "new"
+	DIFF_FAILED = "failed"
+)
+
+// 单项的变化
+const (
+	CHANGE_ADDED   = "added"
+	CHANGE_REMOVED = "removed"
+	CHANGE_CHANGED = "changed"
+)
+
+// Change 一项信息的变化, 新增时只有New, 移除时只有Old
+type Change struct {
+	// 与 MachineDetail 导出的json字段名相同, 组件为 memory, disks, raids, 电源为 psus
+	Item   string `json:"item"`
+	Action string `json:"action"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// DiffResult 单台主机本次采集与数据库中信息的对比
+type DiffResult struct {
+	IP      string   `json:"ip"`
+	SN      string   `json:"sn"`
+	Status  string   `json:"status"`
+	Changes []Change `json:"changes"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// 文本输出使用的名称
+var itemNames = map[string]string{
+	"ip":             "IP",
+	"product_name":   "型号",
+	"os_name":        "操作系统",
+	"kernel_version": "内核版本",
+	"cpu":            "CPU",
+	"mem_total_mb":   "内存总量",
+	"psus":           "电源模块",
+	"memory":         "内存",
+	"disks":          "磁盘",
+	"raids":          "RAID",
+}
+
+// Diff 采集所有主机的机器信息, 按sn与数据库中的信息对比, 结果按IP排序
+// 不写入数据库
+func (p *PlayBook) Diff(ctx context.Context, store db.Store) []DiffResult {
+	var fetched []FetchResult
+	for r := range p.Fetch(ctx) {
+		fetched = append(fetched, r)
+	}
+	SortFetchResults(fetched)
+
+	res := make([]DiffResult, 0, len(fetched))
+	for _, r := range fetched {
+		d := DiffResult{IP: r.Detail.IP, SN: r.Detail.SN, Changes: make([]Change, 0)}
+		if r.Err == nil && !validSN(r.Detail.SN) {
+			r.Err = errors.New("无法获取序列号")
+		}
+		if r.Err != nil {
+			d.Status, d.Error = DIFF_FAILED, failReason(CommandResult{Err: r.Err})
+			res = append(res, d)
+			continue
+		}
+
+		stored, found, err := store.QueryMachine(r.Detail.SN)
+		switch {
+		case err != nil:
+			d.Status, d.Error = DIFF_FAILED, err.Error()
+		case !found:
+			d.Status = DIFF_NEW
+		default:
+			d.Changes = diffMachine(stored, machineInfo(r.Detail))
+			d.Status = DIFF_UNCHANGED
+			if len(d.Changes) != 0 {
+				d.Status = DIFF_CHANGED
+			}
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+func validSN(sn string) bool {
+	return sn != "" && sn != orDenied("")
+}
+
+// 对比数据库中的信息和本次采集的信息
+func diffMachine(old, cur db.Machine_INFO) []Change {
+	changes := make([]Change, 0)
+
+	base := []struct {
+		item     string
+		old, cur string
+	}{
+		{"ip", old.Base.IP, cur.Base.IP},
+		{"product_name", old.Base.Model, cur.Base.Model},
+		{"os_name", old.Base.OS, cur.Base.OS},
+		{"kernel_version", old.Base.KernelVersion, cur.Base.KernelVersion},
+		{"cpu", old.Base.Cpu, cur.Base.Cpu},
+		{"mem_total_mb", old.Base.Memory, cur.Base.Memory},
+		{"psus", old.Base.Power, cur.Base.Power},
+	}
+	for _, v := range base {
+		if v.old != v.cur {
+			changes = append(changes, Change{Item: v.item, Action: CHANGE_CHANGED, Old: v.old, New: v.cur})
+		}
+	}
+
+	// 同一插槽的内存条视为更换
+	removed, added := multisetDiff(old.Memorys, cur.Memorys)
+	for _, r := range removed {
+		i := len(added)
+		for j, a := range added {
+			if a.Location == r.Location {
+				i = j
+				break
+			}
+		}
+		if i < len(added) {
+			changes = append(changes, Change{Item: "memory", Action: CHANGE_CHANGED, Old: memoryText(r), New: memoryText(added[i])})
+			added = append(added[:i], added[i+1:]...)
+			continue
+		}
+		changes = append(changes, Change{Item: "memory", Action: CHANGE_REMOVED, Old: memoryText(r)})
+	}
+	for _, a := range added {
+		changes = append(changes, Change{Item: "memory", Action: CHANGE_ADDED, New: memoryText(a)})
+	}
+
+	// 按序列号对应硬盘, 同型号的硬盘更换后序列号不同, 为移除和新增
+	// 序列号相同时为信息变化; 之前没有采集序列号时, 型号, 容量和介质相同的视为同一块
+	removedDisks, addedDisks := multisetDiff(old.Disks, cur.Disks)
+	for _, r := range removedDisks {
+		i := len(addedDisks)
+		for j, a := range addedDisks {
+			if sameDisk(r, a) {
+				i = j
+				break
+			}
+		}
+		if i < len(addedDisks) {
+			changes = append(changes, Change{Item: "disks", Action: CHANGE_CHANGED, Old: diskText(r), New: diskText(addedDisks[i])})
+			addedDisks = append(addedDisks[:i], addedDisks[i+1:]...)
+			continue
+		}
+		changes = append(changes, Change{Item: "disks", Action: CHANGE_REMOVED, Old: diskText(r)})
+	}
+	for _, v := range addedDisks {
+		changes = append(changes, Change{Item: "disks", Action: CHANGE_ADDED, New: diskText(v)})
+	}
+
+	removedRaids, addedRaids := multisetDiff(old.Raids, cur.Raids)
+	for _, v := range removedRaids {
+		changes = append(changes, Change{Item: "raids", Action: CHANGE_REMOVED, Old: v.Level + " " + v.Capacity})
+	}
+	for _, v := range addedRaids {
+		changes = append(changes, Change{Item: "raids", Action: CHANGE_ADDED, New: v.Level + " " + v.Capacity})
+	}
+
+	return changes
+}
+
+func memoryText(m db.Machine_Memory_INFO_MODEL) string {
+	return m.Location + " " + m.Type + " " + m.Size
+}
+
+func sameDisk(a, b db.Machine_Disk_INFO_MODEL) bool {
+	if a.Serial != "" && b.Serial != "" {
+		return a.Serial == b.Serial
+	}
+	return a.Product == b.Product && a.Capacity == b.Capacity && a.Media == b.Media
+}
+
+func diskText(d db.Machine_Disk_INFO_MODEL) string {
+	text := d.Product + " " + d.Capacity + " " + d.Media
+	if d.Serial != "" {
+		text += " " + d.Serial
+	}
+	return text
+}
+
+// 不区分顺序对比两组信息, 返回只在old中和只在cur中的项, 保持原来的顺序
+func multisetDiff[T comparable](old, cur []T) (removed, added []T) {
+	count := make(map[T]int, len(old))
+	for _, v := range old {
+		count[v]++
+	}
+	for _, v := range cur {
+		if count[v] > 0 {
+			count[v]--
+			continue
+		}
+		added = append(added, v)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		if count[old[i]] > 0 {
+			count[old[i]]--
+			removed = append([]T{old[i]}, removed...)
+		}
+	}
+	return removed, added
+}
+
+// WriteDiffs 按格式输出对比结果, 支持 text 和 json
+func WriteDiffs(w io.Writer, format string, res []DiffResult) error {
+	switch format {
+	case FORMAT_TEXT, "":
+		for _, d := range res {
+			writeDiff(w, d)
+		}
+		return nil
+
+	case FORMAT_JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Hosts []DiffResult `json:"hosts"`
+		}{res})
+	}
+
+	return fmt.Errorf("kwssh: 未知的输出格式 %q", format)
+}
+
+func writeDiff(w io.Writer, d DiffResult) {
+	switch d.Status {
+	case DIFF_FAILED:
+		fmt.Fprintf(w, "IP: [%s] %s\n\n", d.IP, d.Error)
+		return
+	case DIFF_NEW:
+		fmt.Fprintf(w, "IP: [%s] SN: [%s] 数据库中没有该机器\n\n", d.IP, d.SN)
+		return
+	case DIFF_UNCHANGED:
+		fmt.Fprintf(w, "IP: [%s] SN: [%s] 无变化\n\n", d.IP, d.SN)
+		return
+	}
+
+	fmt.Fprintf(w, "IP: [%s] SN: [%s] 有变化:\n", d.IP, d.SN)
+	for _, c := range d.Changes {
+		name := itemNames[c.Item]
+		switch c.Action {
+		case CHANGE_ADDED:
+			fmt.Fprintf(w, "\t新增%s: [%s]\n", name, c.New)
+		case CHANGE_REMOVED:
+			fmt.Fprintf(w, "\t移除%s: [%s]\n", name, c.Old)
+		default:
+			fmt.Fprintf(w, "\t%s: [%s] -> [%s]\n", name, c.Old, c.New)
+		}
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,122 @@
+package kwssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	db "zeus/model"
+)
+
+func TestDiffMachine(t *testing.T) {
+	old := db.Machine_INFO{
+		Base: db.Machine_Base_INFO_MODEL{SN: "7XK2N33", IP: "10.0.0.2", OS: "CentOS 7.9", KernelVersion: "3.10.0-1160", Memory: "32000 MB"},
+		Memorys: []db.Machine_Memory_INFO_MODEL{
+			{SN: "7XK2N33", Location: "A1", Type: "DDR4", Size: "16384 MB"},
+			{SN: "7XK2N33", Location: "A2", Type: "DDR4", Size: "16384 MB"},
+		},
+		Disks: []db.Machine_Disk_INFO_MODEL{
+			{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420J4XL"},
+			{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420K8PQ"},
+			{SN: "7XK2N33", Product: "MZ7KH1T9HAJR0D3", Capacity: "1787.88 GB", Media: "SSD", Serial: "S47PNA0M812345"},
+		},
+		Raids: []db.Machine_RAID_INFO_MODEL{{SN: "7XK2N33", Level: "RAID-1", Capacity: "558.38 GB"}},
+	}
+
+	if c := diffMachine(old, old); len(c) != 0 {
+		t.Fatalf("same machine has changes: %v", c)
+	}
+
+	cur := old
+	cur.Base.KernelVersion = "3.10.0-1160.el7"
+	// 更换一块同型号磁盘, 另一块更换型号, A2 更换为32G, 新增 B1, 顺序与数据库中不同
+	cur.Memorys = []db.Machine_Memory_INFO_MODEL{
+		{SN: "7XK2N33", Location: "B1", Type: "DDR4", Size: "16384 MB"},
+		{SN: "7XK2N33", Location: "A2", Type: "DDR4", Size: "32768 MB"},
+		old.Memorys[0],
+	}
+	cur.Disks = []db.Machine_Disk_INFO_MODEL{
+		{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420Z1AB"},
+		{SN: "7XK2N33", Product: "MZ7KH1T9HAJR0D3", Capacity: "1788 GB", Media: "SSD", Serial: "S47PNA0M812345"},
+		old.Disks[0],
+	}
+	cur.Raids = nil
+
+	want := []Change{
+		{Item: "kernel_version", Action: CHANGE_CHANGED, Old: "3.10.0-1160", New: "3.10.0-1160.el7"},
+		{Item: "memory", Action: CHANGE_CHANGED, Old: "A2 DDR4 16384 MB", New: "A2 DDR4 32768 MB"},
+		{Item: "memory", Action: CHANGE_ADDED, New: "B1 DDR4 16384 MB"},
+		{Item: "disks", Action: CHANGE_REMOVED, Old: "ST600MM0088 558.38 GB HDD W420K8PQ"},
+		{Item: "disks", Action: CHANGE_CHANGED, Old: "MZ7KH1T9HAJR0D3 1787.88 GB SSD S47PNA0M812345", New: "MZ7KH1T9HAJR0D3 1788 GB SSD S47PNA0M812345"},
+		{Item: "disks", Action: CHANGE_ADDED, New: "ST600MM0088 558.38 GB HDD W420Z1AB"},
+		{Item: "raids", Action: CHANGE_REMOVED, Old: "RAID-1 558.38 GB"},
+	}
+	if got := diffMachine(old, cur); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffMachine =\n%v\nwant\n%v", got, want)
+	}
+
+	// 数据库中的信息没有序列号时, 同型号的磁盘不算更换
+	noSerial := old
+	noSerial.Disks = []db.Machine_Disk_INFO_MODEL{
+		{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD"},
+	}
+	upgraded := noSerial
+	upgraded.Disks = []db.Machine_Disk_INFO_MODEL{old.Disks[0]}
+	want = []Change{{Item: "disks", Action: CHANGE_CHANGED, Old: "ST600MM0088 558.38 GB HDD", New: "ST600MM0088 558.38 GB HDD W420J4XL"}}
+	if got := diffMachine(noSerial, upgraded); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffMachine without serial =\n%v\nwant\n%v", got, want)
+	}
+
+	// 与导出的json字段名相同
+	b, _ := json.Marshal(MachineDetail{})
+	var fields map[string]any
+	json.Unmarshal(b, &fields)
+	for item := range itemNames {
+		if _, ok := fields[item]; !ok {
+			t.Errorf("diff item %q is not a MachineDetail json field", item)
+		}
+	}
+}
+
+func TestWriteDiffs(t *testing.T) {
+	res := []DiffResult{
+		{IP: "10.0.0.2", SN: "7XK2N33", Status: DIFF_CHANGED, Changes: []Change{
+			{Item: "kernel_version", Action: CHANGE_CHANGED, Old: "3.10.0", New: "4.18.0"},
+			{Item: "disks", Action: CHANGE_ADDED, New: "ST600MM0099 558.38 GB HDD"},
+		}},
+		{IP: "10.0.0.3", SN: "8XK2N33", Status: DIFF_UNCHANGED, Changes: []Change{}},
+		{IP: "10.0.0.4", Status: DIFF_FAILED, Error: "无法获取序列号", Changes: []Change{}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteDiffs(&buf, FORMAT_TEXT, res); err != nil {
+		t.Fatal(err)
+	}
+	want := "IP: [10.0.0.2] SN: [7XK2N33] 有变化:\n" +
+		"\t内核版本: [3.10.0] -> [4.18.0]\n" +
+		"\t新增磁盘: [ST600MM0099 558.38 GB HDD]\n\n" +
+		"IP: [10.0.0.3] SN: [8XK2N33] 无变化\n\n" +
+		"IP: [10.0.0.4] 无法获取序列号\n\n"
+	if buf.String() != want {
+		t.Errorf("text =\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := WriteDiffs(&buf, FORMAT_JSON, res); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Hosts []DiffResult `json:"hosts"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Hosts, res) {
+		t.Errorf("json round trip = %v", out.Hosts)
+	}
+
+	if err := WriteDiffs(&buf, FORMAT_CSV, res); err == nil {
+		t.Error("csv should not be supported")
+	}
+}
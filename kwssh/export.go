@@ -0,0 +1,218 @@
+package kwssh
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 采集结果的输出格式
+const (
+	// 每台主机一段中文说明, 与 FetchInfo 相同
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+	// 每台主机一行, 内存, 磁盘等组件合并为一列
+	FORMAT_CSV = "csv"
+)
+
+// 采集失败的主机
+type failedHost struct {
+	IP    string `json:"ip" yaml:"ip"`
+	Error string `json:"error" yaml:"error"`
+}
+
+// SortFetchResults 按IP排序, 无法解析为IP的主机排在最后
+func SortFetchResults(res []FetchResult) {
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := net.ParseIP(res[i].Detail.IP), net.ParseIP(res[j].Detail.IP)
+		switch {
+		case a == nil && b == nil:
+			return res[i].Detail.IP < res[j].Detail.IP
+		case a == nil || b == nil:
+			return b == nil
+		}
+		return bytes.Compare(a.To16(), b.To16()) < 0
+	})
+}
+
+// WriteDetails 按格式输出采集结果, 采集失败的主机也会输出
+// json 和 yaml 为 {hosts: [...], failed: [{ip, error}]}, 字段名见 MachineDetail 的tag
+func WriteDetails(w io.Writer, format string, res []FetchResult) error {
+	switch format {
+	case FORMAT_TEXT, "":
+		var buf bytes.Buffer
+		for _, r := range res {
+			if r.Err != nil {
+				fmt.Fprintf(&buf, "IP: [%s] %s\n\n", r.Detail.IP, failReason(CommandResult{Err: r.Err}))
+				continue
+			}
+			writeDetail(&buf, r.Detail)
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+
+	case FORMAT_JSON, FORMAT_YAML:
+		out := struct {
+			Hosts  []MachineDetail `json:"hosts" yaml:"hosts"`
+			Failed []failedHost    `json:"failed" yaml:"failed"`
+		}{Hosts: make([]MachineDetail, 0, len(res)), Failed: make([]failedHost, 0)}
+
+		for _, r := range res {
+			if r.Err != nil {
+				out.Failed = append(out.Failed, failedHost{IP: r.Detail.IP, Error: failReason(CommandResult{Err: r.Err})})
+				continue
+			}
+			out.Hosts = append(out.Hosts, withEmptySlices(r.Detail))
+		}
+
+		if format == FORMAT_JSON {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(out)
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+		return enc.Close()
+
+	case FORMAT_CSV:
+		return writeCSV(w, hostSheet(res))
+	}
+
+	return fmt.Errorf("kwssh: 未知的输出格式 %q", format)
+}
+
+// WriteDetailSheets 在dir下输出每台主机一行的 hosts.csv,
+// 以及每个组件一行的 memory.csv, disks.csv, raids.csv 和 psus.csv, 组件通过 ip 和 sn 关联主机
+func WriteDetailSheets(dir string, res []FetchResult) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	memory := [][]string{{"ip", "sn", "location", "type", "size"}}
+	disks := [][]string{{"ip", "sn", "product", "capacity", "media", "serial"}}
+	raids := [][]string{{"ip", "sn", "level", "size"}}
+	psus := [][]string{{"ip", "sn", "psu"}}
+
+	for _, r := range res {
+		if r.Err != nil {
+			continue
+		}
+		d := r.Detail
+		for _, v := range d.Memorys {
+			memory = append(memory, []string{d.IP, d.SN, v.Location, v.Type, v.Size})
+		}
+		for _, v := range d.Disks {
+			disks = append(disks, []string{d.IP, d.SN, v.Product, v.Capacity, v.Media, v.Serial})
+		}
+		for _, v := range d.Raids {
+			raids = append(raids, []string{d.IP, d.SN, v.Level, v.Size})
+		}
+		for _, v := range d.PSUs {
+			psus = append(psus, []string{d.IP, d.SN, v})
+		}
+	}
+
+	sheets := []struct {
+		name string
+		rows [][]string
+	}{
+		{"hosts.csv", hostSheet(res)},
+		{"memory.csv", memory},
+		{"disks.csv", disks},
+		{"raids.csv", raids},
+		{"psus.csv", psus},
+	}
+	for _, s := range sheets {
+		f, err := os.Create(filepath.Join(dir, s.name))
+		if err != nil {
+			return err
+		}
+		err = writeCSV(f, s.rows)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("kwssh: write %s err: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// 每台主机一行, 组件以 ";" 分隔合并为一列, 失败的主机只有ip和error
+func hostSheet(res []FetchResult) [][]string {
+	rows := [][]string{{
+		"ip", "sn", "vendor", "product_name", "os_name", "kernel_version",
+		"cpu", "cpu_name", "cpu_num", "mem_total_mb",
+		"memory_count", "memory", "disk_count", "disks", "raid_count", "raids", "psu_count", "psus",
+		"error",
+	}}
+
+	for _, r := range res {
+		d := r.Detail
+		if r.Err != nil {
+			row := make([]string, len(rows[0]))
+			row[0], row[len(row)-1] = d.IP, failReason(CommandResult{Err: r.Err})
+			rows = append(rows, row)
+			continue
+		}
+
+		var memory, disks, raids []string
+		for _, v := range d.Memorys {
+			memory = append(memory, v.Location+" "+v.Size)
+		}
+		for _, v := range d.Disks {
+			disks = append(disks, v.Product+" "+v.Capacity+" "+v.Media)
+		}
+		for _, v := range d.Raids {
+			raids = append(raids, v.Level+" "+v.Size)
+		}
+
+		rows = append(rows, []string{
+			d.IP, d.SN, d.Vendor, d.ProductName, d.OSName, d.KernelVersion,
+			d.CPU, d.CPUName, d.CPUNum, d.MemTotal,
+			strconv.Itoa(len(memory)), strings.Join(memory, ";"),
+			strconv.Itoa(len(disks)), strings.Join(disks, ";"),
+			strconv.Itoa(len(raids)), strings.Join(raids, ";"),
+			strconv.Itoa(len(d.PSUs)), strings.Join(d.PSUs, ";"),
+			"",
+		})
+	}
+	return rows
+}
+
+func writeCSV(w io.Writer, rows [][]string) error {
+	cw := csv.NewWriter(w)
+	cw.WriteAll(rows)
+	return cw.Error()
+}
+
+// 没有采集到的组件输出为空列表而不是null, 保持输出格式稳定
+func withEmptySlices(d MachineDetail) MachineDetail {
+	if d.Memorys == nil {
+		d.Memorys = []Memory{}
+	}
+	if d.Disks == nil {
+		d.Disks = []Disk{}
+	}
+	if d.Raids == nil {
+		d.Raids = []Raid{}
+	}
+	if d.PSUs == nil {
+		d.PSUs = []string{}
+	}
+	return d
+}
@@ -0,0 +1,159 @@
+package kwssh
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testFetchResults() []FetchResult {
+	return []FetchResult{
+		{Detail: MachineDetail{IP: "10.0.0.10"}, Err: errors.New("connection refused")},
+		{Detail: MachineDetail{
+			IP:          "10.0.0.2",
+			ProductName: "PowerEdge R740",
+			Vendor:      VENDOR_DELL,
+			SN:          "7XK2N33",
+			CPU:         "Gold 6230 x 2",
+			MemTotal:    "32000",
+			Memorys:     []Memory{{Location: "A1", Type: "DDR4", Size: "16384 MB"}, {Location: "A2", Type: "DDR4", Size: "16384 MB"}},
+			Disks:       []Disk{{Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420J4XL"}},
+			PSUs:        []string{"750 W", "750 W"},
+			Power:       "750 W; 750 W",
+		}},
+	}
+}
+
+func TestSortFetchResults(t *testing.T) {
+	res := append(testFetchResults(), FetchResult{Detail: MachineDetail{IP: "web01"}})
+	SortFetchResults(res)
+
+	var got []string
+	for _, r := range res {
+		got = append(got, r.Detail.IP)
+	}
+	if want := []string{"10.0.0.2", "10.0.0.10", "web01"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sorted = %v, want %v", got, want)
+	}
+}
+
+func TestWriteDetailsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDetails(&buf, FORMAT_JSON, testFetchResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Hosts  []map[string]any `json:"hosts"`
+		Failed []failedHost     `json:"failed"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Hosts) != 1 || len(out.Failed) != 1 || out.Failed[0].IP != "10.0.0.10" {
+		t.Fatalf("unexpected json:\n%s", buf.String())
+	}
+
+	host := out.Hosts[0]
+	if host["sn"] != "7XK2N33" || host["mem_total_mb"] != "32000" {
+		t.Errorf("host = %v", host)
+	}
+	// 没有采集到的组件为空列表
+	if raids, ok := host["raids"].([]any); !ok || len(raids) != 0 {
+		t.Errorf("raids = %#v, want []", host["raids"])
+	}
+	if _, ok := host["Power"]; ok {
+		t.Errorf("Power should not be exported, got %v", host)
+	}
+}
+
+func TestWriteDetailsYAML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDetails(&buf, FORMAT_YAML, testFetchResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Hosts []MachineDetail `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Hosts) != 1 || out.Hosts[0].Memorys[1].Location != "A2" || out.Hosts[0].PSUs[0] != "750 W" {
+		t.Fatalf("unexpected yaml:\n%s", buf.String())
+	}
+}
+
+func TestWriteDetailsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteDetails(&buf, FORMAT_CSV, testFetchResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want header + 2", len(rows))
+	}
+
+	col := func(row []string, name string) string {
+		for i, v := range rows[0] {
+			if v == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("column %q not found", name)
+		return ""
+	}
+	if col(rows[1], "error") != "connection refused" || col(rows[1], "sn") != "" {
+		t.Errorf("failed host row = %v", rows[1])
+	}
+	if col(rows[2], "memory_count") != "2" || col(rows[2], "memory") != "A1 16384 MB;A2 16384 MB" {
+		t.Errorf("memory columns = %v", rows[2])
+	}
+	if col(rows[2], "disks") != "ST600MM0088 558.38 GB HDD" || col(rows[2], "psus") != "750 W;750 W" {
+		t.Errorf("component columns = %v", rows[2])
+	}
+
+	if err := WriteDetails(&buf, "xml", nil); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
+
+func TestWriteDetailSheets(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sheets")
+	if err := WriteDetailSheets(dir, testFetchResults()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"memory.csv": "ip,sn,location,type,size\n10.0.0.2,7XK2N33,A1,DDR4,16384 MB\n10.0.0.2,7XK2N33,A2,DDR4,16384 MB\n",
+		"disks.csv":  "ip,sn,product,capacity,media,serial\n10.0.0.2,7XK2N33,ST600MM0088,558.38 GB,HDD,W420J4XL\n",
+		"raids.csv":  "ip,sn,level,size\n",
+		"psus.csv":   "ip,sn,psu\n10.0.0.2,7XK2N33,750 W\n10.0.0.2,7XK2N33,750 W\n",
+	}
+	for name, content := range want {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("%s =\n%s\nwant\n%s", name, b, content)
+		}
+	}
+
+	b, _ := os.ReadFile(filepath.Join(dir, "hosts.csv"))
+	if lines := strings.Count(string(b), "\n"); lines != 3 {
+		t.Errorf("hosts.csv has %d lines, want 3", lines)
+	}
+}
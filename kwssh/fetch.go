@@ -0,0 +1,197 @@
+package kwssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	db "zeus/model"
+)
+
+// FetchResult 单台主机采集到的机器信息
+type FetchResult struct {
+	Detail MachineDetail
+	// 连接失败等主机级别的错误, 此时 Detail 中只有IP
+	Err error
+}
+
+// Fetch 采集所有主机的机器信息, 每台主机的结果推送到返回的channel
+// 不修改Task中的命令
+func (p *PlayBook) Fetch(ctx context.Context) <-chan FetchResult {
+	out := make(chan FetchResult, len(p.m))
+
+	go func() {
+		defer close(out)
+		for res := range p.exec(ctx, fetchTask) {
+			if res.Err != nil {
+				out <- FetchResult{Detail: MachineDetail{IP: res.IP}, Err: res.Err}
+				continue
+			}
+			out <- FetchResult{Detail: ParseMachineDetail(res)}
+		}
+	}()
+
+	return out
+}
+
+// 采集机器信息, 先执行基础命令, 再按厂商依次执行collector的命令, 直到硬件信息完整
+func fetchTask(ctx context.Context, cli *SSH, t *Task) CommandResult {
+	defer cli.Close()
+
+	r := cli.run(ctx, fetchCommands)
+	if r.Err != nil || connLost(r) {
+		return r
+	}
+
+	for _, c := range collectorsFor(VendorOf(stdoutOf(r, productName))) {
+		if parseHardware(r.Res).complete() {
+			break
+		}
+
+		res := cli.run(ctx, c.commands())
+		r.Res = append(r.Res, res.Res...)
+		if res.Err != nil || connLost(res) {
+			r.Err = res.Err
+			break
+		}
+	}
+	return r
+}
+
+// 采集机器信息写入到标准输出
+// 有任意主机失败时返回错误, 输出失败的主机也计为失败
+func (p *PlayBook) FetchInfo(ctx context.Context) error {
+	failed, total := 0, 0
+	var werr error
+	// 每台主机采集完成后立即输出
+	for r := range p.Fetch(ctx) {
+		total++
+		err := WriteDetails(p.out, FORMAT_TEXT, []FetchResult{r})
+		if err != nil && werr == nil {
+			werr = fmt.Errorf("kwssh: playbook [%s]: write output err: %w", p.name, err)
+		}
+		if r.Err != nil || err != nil {
+			failed++
+		}
+	}
+	return errors.Join(p.fetchError(failed, total), werr)
+}
+
+func (p *PlayBook) fetchError(failed, total int) error {
+	if failed != 0 {
+		return fmt.Errorf("kwssh: playbook [%s]: %d/%d hosts failed", p.name, failed, total)
+	}
+	return nil
+}
+
+func writeDetail(w io.Writer, detail MachineDetail) {
+	fmt.Fprintf(w, "%-9s:\t%s\n%-7s:\t[%s]\n%-6s:\t[%s]\n%-5s:\t[%s]\n%-5s:\t[%s]\n%-9s:\t[%s]\n%-7s:\t[%s MB]\n",
+		"IP", detail.IP, "型号", detail.ProductName, "序列号", detail.SN, "操作系统", detail.OSName, "内核版本", detail.KernelVersion, "CPU", detail.CPU,
+		"内存", detail.MemTotal)
+
+	if len(detail.Power) != 0 {
+		fmt.Fprintf(w, "%-5s:\t[%s]\n", "电源模块", detail.Power)
+	}
+
+	if len(detail.Memorys) != 0 {
+		fmt.Fprintln(w, "内存位置信息 :")
+		for _, v := range detail.Memorys {
+			fmt.Fprintf(w, "\t内存位置: [%s] 内存类型: [%s] 内存容量: [%s]\n", v.Location, v.Type, v.Size)
+		}
+	}
+
+	if len(detail.Disks) != 0 {
+		fmt.Fprintln(w, "磁盘信息 :")
+		for _, v := range detail.Disks {
+			fmt.Fprintf(w, "\t磁盘: [%s] 容量: [%s] 介质: [%s] 序列号: [%s]\n", v.Product, v.Capacity, v.Media, v.Serial)
+		}
+	}
+
+	if len(detail.Raids) != 0 {
+		fmt.Fprintln(w, "RAID信息 :")
+		for _, v := range detail.Raids {
+			fmt.Fprintf(w, "\tRAID Level: [%s] 容量: [%s]\n", v.Level, v.Size)
+		}
+	}
+
+	fmt.Fprintln(w)
+}
+
+// 采集机器信息写入到数据库
+// 表结构不是最新版本时不采集; 有主机采集或写入失败时返回错误
+func (p *PlayBook) FetchInfoToDB(ctx context.Context, store db.Store) error {
+	err := store.CheckSchema()
+	if err != nil {
+		return err
+	}
+
+	failed, total := 0, 0
+	for r := range p.Fetch(ctx) {
+		total++
+		// 以sn为主键, 没有sn的主机会互相覆盖
+		if r.Err == nil && !validSN(r.Detail.SN) {
+			r.Err = errors.New("无法获取序列号, 不写入数据库")
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(p.out, "IP: [%s] %s\n\n", r.Detail.IP, failReason(CommandResult{Err: r.Err}))
+			continue
+		}
+
+		err := store.WriteToDB(machineInfo(r.Detail))
+		if err != nil {
+			failed++
+			fmt.Fprintf(p.out, "IP: [%s] db.WriteToDB(info) err, err=%#v\n\n", r.Detail.IP, err.Error())
+			continue
+		}
+	}
+	return p.fetchError(failed, total)
+}
+
+// 组装写入数据库的数据
+func machineInfo(detail MachineDetail) db.Machine_INFO {
+	info := db.Machine_INFO{}
+
+	info.Disks = make([]db.Machine_Disk_INFO_MODEL, 0)
+	info.Raids = make([]db.Machine_RAID_INFO_MODEL, 0)
+	info.Memorys = make([]db.Machine_Memory_INFO_MODEL, 0)
+
+	info.Base.Cpu = detail.CPU
+	info.Base.IP = detail.IP
+	info.Base.KernelVersion = detail.KernelVersion
+	info.Base.Memory = detail.MemTotal + " MB"
+	info.Base.Model = detail.ProductName
+	info.Base.OS = detail.OSName
+	info.Base.SN = detail.SN
+	info.Base.Power = detail.Power
+
+	for _, v := range detail.Memorys {
+		info.Memorys = append(info.Memorys, db.Machine_Memory_INFO_MODEL{
+			SN:       info.Base.SN,
+			Size:     v.Size,
+			Type:     v.Type,
+			Location: v.Location,
+		})
+	}
+
+	for _, v := range detail.Disks {
+		info.Disks = append(info.Disks, db.Machine_Disk_INFO_MODEL{
+			SN:       info.Base.SN,
+			Media:    v.Media,
+			Capacity: v.Capacity,
+			Product:  v.Product,
+			Serial:   v.Serial,
+		})
+	}
+
+	for _, v := range detail.Raids {
+		info.Raids = append(info.Raids, db.Machine_RAID_INFO_MODEL{
+			SN:       info.Base.SN,
+			Level:    v.Level,
+			Capacity: v.Size,
+		})
+	}
+
+	return info
+}
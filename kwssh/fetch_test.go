@@ -0,0 +1,158 @@
+package kwssh
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"zeus/kwssh/sshtest"
+	db "zeus/model"
+)
+
+func TestMachineInfo(t *testing.T) {
+	info := machineInfo(MachineDetail{
+		IP:          "10.0.0.1",
+		SN:          "7XK2N33",
+		ProductName: "PowerEdge R740",
+		CPU:         "Gold 6230 x 2",
+		MemTotal:    "32000",
+		Memorys:     []Memory{{Location: "A1", Type: "DDR4", Size: "16384 MB"}},
+		Disks:       []Disk{{Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD"}},
+		Raids:       []Raid{{Level: "RAID-1", Size: "558.38 GB"}},
+	})
+
+	want := db.Machine_INFO{
+		Base:    db.Machine_Base_INFO_MODEL{SN: "7XK2N33", IP: "10.0.0.1", Model: "PowerEdge R740", Cpu: "Gold 6230 x 2", Memory: "32000 MB"},
+		Memorys: []db.Machine_Memory_INFO_MODEL{{SN: "7XK2N33", Location: "A1", Type: "DDR4", Size: "16384 MB"}},
+		Disks:   []db.Machine_Disk_INFO_MODEL{{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD"}},
+		Raids:   []db.Machine_RAID_INFO_MODEL{{SN: "7XK2N33", Level: "RAID-1", Capacity: "558.38 GB"}},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("machineInfo =\n%+v\nwant\n%+v", info, want)
+	}
+}
+
+func TestFetchInfoToDB(t *testing.T) {
+	cfg := db.DefaultConfig()
+	cfg.DSN = "sqlite://" + filepath.Join(t.TempDir(), "idc.db")
+	store, err := db.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	dell := func(kernel string) *sshtest.Server {
+		return newServer(t, sshtest.Config{
+			Commands: map[string]sshtest.Response{
+				productName:   {Stdout: " PowerEdge R740\n"},
+				sn:            {Stdout: "7XK2N33\n"},
+				kernelVersion: {Stdout: kernel + "\n"},
+				osName:        {Stdout: "CentOS Linux 7 (Core)\n"},
+				diskInfo:      {Stdout: readFixture(t, "omreport_pdisk.txt")},
+				raidInfo:      {Stdout: readFixture(t, "omreport_vdisk.txt")},
+				mems:          {Stdout: readFixture(t, "omreport_memory.txt")},
+				pwrsupplies:   {Stdout: " 750 W\n"},
+			},
+			Default: &sshtest.Response{},
+		})
+	}
+	ctx := context.Background()
+
+	// 表结构不是最新版本时不写入
+	s := dell("3.10.0")
+	if err := newTestPlayBook(t, s, "fetch", 1).FetchInfoToDB(ctx, store); err == nil {
+		t.Fatal("FetchInfoToDB should fail before migrate")
+	}
+	if _, err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := store.QueryMachine("7XK2N33"); found {
+		t.Fatal("machine written before migrate")
+	}
+
+	diff := newTestPlayBook(t, s, "fetch", 1).Diff(ctx, store)
+	if len(diff) != 1 || diff[0].Status != DIFF_NEW {
+		t.Fatalf("diff before fetchToDB = %+v", diff)
+	}
+
+	if err := newTestPlayBook(t, s, "fetch", 1).FetchInfoToDB(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	info, found, err := store.QueryMachine("7XK2N33")
+	if err != nil || !found {
+		t.Fatalf("found=%v err=%v", found, err)
+	}
+	if info.Base.Model != "PowerEdge R740" || info.Base.KernelVersion != "3.10.0" || info.Base.Power != "750 W" {
+		t.Errorf("base = %+v", info.Base)
+	}
+	if len(info.Disks) != len(parseDiskInfo(readFixture(t, "omreport_pdisk.txt"))) || len(info.Raids) == 0 || len(info.Memorys) == 0 {
+		t.Errorf("components = %+v", info)
+	}
+
+	// 重复采集不重复写入组件
+	newTestPlayBook(t, s, "fetch", 1).FetchInfoToDB(ctx, store)
+	again, _, _ := store.QueryMachine("7XK2N33")
+	if !reflect.DeepEqual(again, info) {
+		t.Errorf("second fetchToDB changed machine:\n%+v\n%+v", again, info)
+	}
+	if diff := newTestPlayBook(t, s, "fetch", 1).Diff(ctx, store); diff[0].Status != DIFF_UNCHANGED {
+		t.Errorf("diff = %+v", diff)
+	}
+
+	// 内核升级
+	upgraded := dell("4.18.0")
+	diff = newTestPlayBook(t, upgraded, "fetch", 1).Diff(ctx, store)
+	want := []Change{{Item: "kernel_version", Action: CHANGE_CHANGED, Old: "3.10.0", New: "4.18.0"}}
+	if diff[0].Status != DIFF_CHANGED || !reflect.DeepEqual(diff[0].Changes, want) {
+		t.Errorf("diff = %+v", diff)
+	}
+
+	newTestPlayBook(t, upgraded, "fetch", 1).FetchInfoToDB(ctx, store)
+	history, err := store.QueryHistory("7XK2N33")
+	if err != nil || len(history) != 1 {
+		t.Fatalf("history = %v, err=%v", history, err)
+	}
+	if old, _ := history[0].Info(); old.Base.KernelVersion != "3.10.0" {
+		t.Errorf("history snapshot = %+v", old.Base)
+	}
+
+	// 以sn为主键, 无法获取sn的主机不写入, 不会互相覆盖
+	t.Run("without sn", func(t *testing.T) {
+		// 普通用户执行dmidecode没有输出, 两台主机的sn都无法获取
+		noSN := func(kernel string) *sshtest.Server {
+			return newServer(t, sshtest.Config{
+				Commands: map[string]sshtest.Response{
+					productName:   {Stdout: " PowerEdge R740\n"},
+					kernelVersion: {Stdout: kernel + "\n"},
+				},
+				Default: &sshtest.Response{},
+			})
+		}
+		pb := New("fetch", 2)
+		pb.SetOutput(io.Discard)
+		for _, s := range []*sshtest.Server{noSN("3.10.0"), noSN("4.18.0")} {
+			if err := pb.AddTask("fetch", testTask(s)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := pb.FetchInfoToDB(context.Background(), store); err == nil || !strings.Contains(err.Error(), "2/2 hosts failed") {
+			t.Errorf("FetchInfoToDB err = %v, want 2/2 hosts failed", err)
+		}
+
+		for _, sn := range []string{"", orDenied("")} {
+			if _, found, err := store.QueryMachine(sn); err != nil || found {
+				t.Errorf("machine with sn %q written, err=%v", sn, err)
+			}
+			if history, err := store.QueryHistory(sn); err != nil || len(history) != 0 {
+				t.Errorf("history for sn %q = %v, err=%v", sn, history, err)
+			}
+		}
+
+		if err := store.WriteToDB(db.Machine_INFO{}); err == nil {
+			t.Error("WriteToDB with empty sn should fail")
+		}
+	})
+}
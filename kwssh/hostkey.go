@@ -0,0 +1,136 @@
+package kwssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机公钥校验策略, 0 表示沿用PlayBook的设置(默认为严格模式)
+const (
+	_ = iota
+	// 只信任known_hosts中已存在的公钥
+	HOSTKEY_STRICT
+	// 首次连接时信任并追加到known_hosts, 之后严格校验
+	HOSTKEY_TOFU
+	// 不校验主机公钥, 只有显式指定时才使用
+	HOSTKEY_INSECURE
+)
+
+// 并发追加known_hosts时加锁
+var knownHostsMu sync.Mutex
+
+// HostKeyError 主机公钥校验失败(公钥不一致, 公钥已吊销或严格模式下主机未知)
+type HostKeyError struct {
+	Host string
+	Err  error
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("kwssh: host key verification failed for [%s], err=%s", e.Host, e.Err)
+}
+
+func (e *HostKeyError) Unwrap() error {
+	return e.Err
+}
+
+// ParseHostKeyPolicy 解析主机公钥校验策略, 空字符串返回0(沿用PlayBook的设置)
+func ParseHostKeyPolicy(s string) (int, error) {
+	switch s {
+	case "":
+		return 0, nil
+	case "strict":
+		return HOSTKEY_STRICT, nil
+	case "tofu":
+		return HOSTKEY_TOFU, nil
+	case "insecure":
+		return HOSTKEY_INSECURE, nil
+	}
+	return 0, fmt.Errorf("kwssh: unknown host key policy %q", s)
+}
+
+// 默认使用 ~/.ssh/known_hosts
+func defaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+func hostKeyCallback(target *Task) (ssh.HostKeyCallback, error) {
+	file := target.KnownHosts
+	if file == "" {
+		file = defaultKnownHosts()
+	}
+
+	switch target.HostKeyPolicy {
+	case HOSTKEY_INSECURE:
+		return ssh.InsecureIgnoreHostKey(), nil
+
+	case HOSTKEY_TOFU:
+		if file == "" {
+			return nil, fmt.Errorf("kwssh: known_hosts file not specified")
+		}
+		return tofuCallback(file), nil
+
+	case 0, HOSTKEY_STRICT:
+		if file == "" {
+			return nil, fmt.Errorf("kwssh: known_hosts file not specified")
+		}
+		cb, err := knownhosts.New(file)
+		if err != nil {
+			return nil, fmt.Errorf("kwssh: load known_hosts [%s] failed, err=%#v", file, err.Error())
+		}
+		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := cb(hostname, remote, key); err != nil {
+				return &HostKeyError{Host: hostname, Err: err}
+			}
+			return nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("kwssh: unknown host key policy %d", target.HostKeyPolicy)
+}
+
+// 首次信任: 每次校验时重新读取known_hosts, 这样并发连接追加的公钥也能被看到
+func tofuCallback(file string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMu.Lock()
+		defer knownHostsMu.Unlock()
+
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		cb, err := knownhosts.New(file)
+		if err != nil {
+			return err
+		}
+
+		err = cb(hostname, remote, key)
+		if err == nil {
+			return nil
+		}
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			// 未知主机, 记录公钥
+			_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+			return err
+		}
+
+		return &HostKeyError{Host: hostname, Err: err}
+	}
+}
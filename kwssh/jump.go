@@ -0,0 +1,221 @@
+package kwssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 同一次执行中复用的跳板机连接, key 见 jumpKey
+type jumpPool struct {
+	mu      sync.Mutex
+	clients map[string]*jumpConn
+	// 从池中移除的连接, 可能还有任务在使用, 在 Close 时关闭
+	stale []*jumpConn
+}
+
+type jumpConn struct {
+	ready  chan struct{}
+	client *ssh.Client
+	err    error
+	// 第几跳, 从1开始
+	depth int
+}
+
+func newJumpPool() *jumpPool {
+	return &jumpPool{clients: make(map[string]*jumpConn)}
+}
+
+// 获取跳板机连接, 不存在时调用dial建立; 同一跳板机的并发请求只建立一次连接
+// 连接失败不缓存, 后续任务会重新连接; 等待其它任务建立连接时 ctx 取消则返回
+func (p *jumpPool) get(ctx context.Context, key string, depth int, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	p.mu.Lock()
+	c, ok := p.clients[key]
+	if !ok {
+		c = &jumpConn{ready: make(chan struct{}), depth: depth}
+		p.clients[key] = c
+	}
+	p.mu.Unlock()
+
+	if ok {
+		select {
+		case <-c.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if c.err == nil {
+			return c.client, nil
+		}
+		return p.get(ctx, key, depth, dial)
+	}
+
+	c.client, c.err = dial()
+	if c.err != nil {
+		p.mu.Lock()
+		if p.clients[key] == c {
+			delete(p.clients, key)
+		}
+		p.mu.Unlock()
+	}
+	close(c.ready)
+	return c.client, c.err
+}
+
+// 通过跳板机连接失败时从池中移除该连接, 后续任务重新连接
+// 其它任务可能还在使用, 不立即关闭
+func (p *jumpPool) drop(client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for k, c := range p.clients {
+		if c.client == client {
+			delete(p.clients, k)
+			p.stale = append(p.stale, c)
+		}
+	}
+}
+
+// 关闭所有跳板机连接, 后面的跳先关闭
+func (p *jumpPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.stale
+	for _, c := range p.clients {
+		conns = append(conns, c)
+	}
+	sort.SliceStable(conns, func(i, j int) bool { return conns[i].depth > conns[j].depth })
+	for _, c := range conns {
+		if c.client != nil {
+			c.client.Close()
+		}
+	}
+	p.clients = make(map[string]*jumpConn)
+	p.stale = nil
+}
+
+// 跳板机连接池的key: 从第一跳到当前跳的完整路径, 登录用户, 认证方式和主机公钥校验策略
+// 认证信息不同的任务不共用连接; 密码只保存摘要
+func jumpKey(target *Task, hops int, user string) string {
+	secret := sha256.Sum256([]byte(target.Pass + "\x00" + target.Passphrase))
+	return strings.Join([]string{
+		strings.Join(target.ProxyJump[:hops], ","),
+		user,
+		fmt.Sprint(target.SSHType, target.AuthMethods),
+		strings.Join(append([]string{target.KeyPath}, target.KeyPaths...), ","),
+		hex.EncodeToString(secret[:]),
+		strconv.Itoa(target.HostKeyPolicy),
+		target.KnownHosts,
+	}, "|")
+}
+
+// 解析跳板机 [user@]host[:port], 未指定用户时使用目标主机的用户, 端口默认22
+func parseJump(hop, defUser string) (user, addr string, err error) {
+	user = defUser
+	if u, h, ok := strings.Cut(hop, "@"); ok {
+		user, hop = u, h
+	}
+	if hop == "" || user == "" {
+		return "", "", fmt.Errorf("kwssh: invalid jump host %q", hop)
+	}
+
+	host, port, err := net.SplitHostPort(hop)
+	if err != nil {
+		// 没有端口
+		host, port = strings.Trim(hop, "[]"), "22"
+	}
+	if _, err := strconv.Atoi(port); err != nil || host == "" {
+		return "", "", fmt.Errorf("kwssh: invalid jump host %q", hop)
+	}
+	return user, net.JoinHostPort(host, port), nil
+}
+
+// 依次连接各个跳板机, 返回最后一跳的连接
+func (s *SSH) dialJumps(ctx context.Context, target *Task) (*ssh.Client, error) {
+	var prev *ssh.Client
+
+	for i, hop := range target.ProxyJump {
+		user, addr, err := parseJump(hop, target.User)
+		if err != nil {
+			return nil, err
+		}
+
+		via := prev
+		dial := func() (*ssh.Client, error) {
+			config, agentConn, err := clientConfig(target, user)
+			if err != nil {
+				return nil, err
+			}
+			if agentConn != nil {
+				defer agentConn.Close()
+			}
+
+			var client *ssh.Client
+			if via == nil {
+				client, err = dialContext(ctx, addr, config)
+			} else {
+				client, err = dialVia(ctx, via, addr, config)
+				s.dropJump(via, err)
+			}
+			if err != nil {
+				var hkErr *HostKeyError
+				if errors.As(err, &hkErr) {
+					return nil, hkErr
+				}
+				return nil, fmt.Errorf("kwssh: connect to jump host [%s] failed, err=%#v", addr, err.Error())
+			}
+			return client, nil
+		}
+
+		if s.jumps != nil {
+			prev, err = s.jumps.get(ctx, jumpKey(target, i+1, user), i+1, dial)
+		} else {
+			prev, err = dial()
+			if err == nil {
+				s.hops = append(s.hops, prev)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return prev, nil
+}
+
+// 通过跳板机连接目标主机
+func dialVia(ctx context.Context, via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &viaError{err}
+	}
+	return handshake(ctx, conn, addr, config)
+}
+
+// 跳板机无法建立到下一跳的连接
+type viaError struct {
+	err error
+}
+
+func (e *viaError) Error() string { return e.err.Error() }
+func (e *viaError) Unwrap() error { return e.err }
+
+// 跳板机连接已经不可用时从连接池中移除
+// 跳板机拒绝转发(目标端口不通等)时连接本身正常, 继续复用
+func (s *SSH) dropJump(via *ssh.Client, err error) {
+	var vErr *viaError
+	var chErr *ssh.OpenChannelError
+	if s.jumps == nil || !errors.As(err, &vErr) || errors.As(err, &chErr) {
+		return
+	}
+	s.jumps.drop(via)
+}
@@ -0,0 +1,197 @@
+package kwssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"zeus/kwssh/sshtest"
+)
+
+func TestParseJump(t *testing.T) {
+	cases := []struct {
+		hop, user, addr string
+	}{
+		{"10.0.0.1", "root", "10.0.0.1:22"},
+		{"ops@10.0.0.1:2222", "ops", "10.0.0.1:2222"},
+		{"bastion.example.com", "root", "bastion.example.com:22"},
+		{"ops@[fe80::1]:22", "ops", "[fe80::1]:22"},
+	}
+	for _, c := range cases {
+		user, addr, err := parseJump(c.hop, "root")
+		if err != nil {
+			t.Errorf("parseJump(%q): %v", c.hop, err)
+			continue
+		}
+		if user != c.user || addr != c.addr {
+			t.Errorf("parseJump(%q) = %s, %s, want %s, %s", c.hop, user, addr, c.user, c.addr)
+		}
+	}
+
+	for _, bad := range []string{"", "ops@", "10.0.0.1:ssh"} {
+		if _, _, err := parseJump(bad, "root"); err == nil {
+			t.Errorf("parseJump(%q) should fail", bad)
+		}
+	}
+}
+
+func TestProxyJump(t *testing.T) {
+	bastion := newServer(t, sshtest.Config{})
+	target := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{"hostname": {Stdout: "target\n"}},
+	})
+
+	task := testTask(target, "hostname")
+	task.ProxyJump = []string{bastion.Addr()}
+
+	cli := dial(t, task)
+	res, err := cli.RunCommands(context.Background(), task.Command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK() || string(res.Res[0].Stdout) != "target\n" {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.IP != target.Host() {
+		t.Errorf("IP = %s, want %s", res.IP, target.Host())
+	}
+	if n := len(bastion.Executed()); n != 0 {
+		t.Errorf("bastion executed %d commands", n)
+	}
+	if cli.hops != nil {
+		t.Error("jump connections should be closed with the client")
+	}
+}
+
+func TestProxyJumpFailure(t *testing.T) {
+	bastion := newServer(t, sshtest.Config{
+		Users: map[string]sshtest.User{"ops": {Password: "other"}},
+	})
+	target := newServer(t, sshtest.Config{})
+
+	task := testTask(target, "hostname")
+	task.ProxyJump = []string{"ops@" + bastion.Addr()}
+
+	err := (&SSH{}).NewClient(context.Background(), &task)
+	if err == nil || !strings.Contains(err.Error(), "jump host") {
+		t.Fatalf("expected jump host error, got %v", err)
+	}
+	if len(target.Executed()) != 0 || target.Logins() != 0 {
+		t.Error("target should not be reached")
+	}
+}
+
+func TestPlayBookJumpReuse(t *testing.T) {
+	bastion := newServer(t, sshtest.Config{})
+	target := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{"hostname": {Stdout: "target\n"}},
+	})
+
+	pb := New("jump", 3)
+	pb.SetOutput(io.Discard)
+	for i := 0; i < 6; i++ {
+		task := testTask(target, "hostname")
+		task.ProxyJump = []string{"root@" + bastion.Addr()}
+		if err := pb.AddTask("jump", task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect(t, pb, "target\n")
+	if n := bastion.Logins(); n != 1 {
+		t.Errorf("bastion logins = %d, want 1", n)
+	}
+	if n := target.Logins(); n != 6 {
+		t.Errorf("target logins = %d, want 6", n)
+	}
+
+	// 每次执行使用新的跳板机连接
+	collect(t, pb, "target\n")
+	if n := bastion.Logins(); n != 2 {
+		t.Errorf("bastion logins after second run = %d, want 2", n)
+	}
+}
+
+func TestJumpKey(t *testing.T) {
+	base := Task{User: "root", Pass: "secret", ProxyJump: []string{"10.0.0.1", "10.0.0.2"}}
+	key := jumpKey(&base, 2, "root")
+
+	if jumpKey(&base, 1, "root") == key || jumpKey(&base, 2, "ops") == key {
+		t.Error("key should contain the jump path and user")
+	}
+	if strings.Contains(key, "secret") {
+		t.Errorf("key contains the password: %s", key)
+	}
+
+	same := base
+	if jumpKey(&same, 2, "root") != key {
+		t.Error("same task should share the key")
+	}
+
+	for name, change := range map[string]func(*Task){
+		"password":    func(t *Task) { t.Pass = "other" },
+		"key":         func(t *Task) { t.KeyPath = "/root/.ssh/id_ed25519" },
+		"auth":        func(t *Task) { t.AuthMethods = []int{AGENT, PASSWORD} },
+		"host key":    func(t *Task) { t.HostKeyPolicy = HOSTKEY_INSECURE },
+		"known hosts": func(t *Task) { t.KnownHosts = "/tmp/known_hosts" },
+		"passphrase":  func(t *Task) { t.Passphrase = "pass" },
+		"ssh type":    func(t *Task) { t.SSHType = PUBLICKEY },
+		"extra keys":  func(t *Task) { t.KeyPaths = []string{"/root/.ssh/id_rsa"} },
+	} {
+		other := base
+		change(&other)
+		if jumpKey(&other, 2, "root") == key {
+			t.Errorf("different %s should not share the jump connection", name)
+		}
+	}
+}
+
+func TestJumpPoolWaitCanceled(t *testing.T) {
+	pool := newJumpPool()
+	release := make(chan struct{})
+	dialing := make(chan struct{})
+	go pool.get(context.Background(), "k", 1, func() (*ssh.Client, error) {
+		close(dialing)
+		<-release
+		return nil, errors.New("refused")
+	})
+	<-dialing
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := pool.get(ctx, "k", 1, func() (*ssh.Client, error) {
+		t.Error("waiter should not dial")
+		return nil, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	close(release)
+}
+
+func TestJumpPoolDrop(t *testing.T) {
+	pool := newJumpPool()
+	dials := 0
+	dial := func() (*ssh.Client, error) {
+		dials++
+		return &ssh.Client{}, nil
+	}
+
+	first, _ := pool.get(context.Background(), "k", 1, dial)
+	if c, _ := pool.get(context.Background(), "k", 1, dial); c != first || dials != 1 {
+		t.Fatalf("pooled client not reused, dials = %d", dials)
+	}
+
+	pool.drop(first)
+	second, _ := pool.get(context.Background(), "k", 1, dial)
+	if second == first || dials != 2 {
+		t.Errorf("dropped client reused, dials = %d", dials)
+	}
+	if len(pool.stale) != 1 || pool.stale[0].client != first {
+		t.Error("dropped client should be closed with the pool")
+	}
+}
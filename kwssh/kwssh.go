@@ -1,20 +1,26 @@
 package kwssh
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strconv"
+	"io"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
 	"zeus/gate"
-	db "zeus/model"
 )
 
 const (
 	_ = iota
 	PASSWORD
 	PUBLICKEY
+	// 使用 SSH_AUTH_SOCK 指向的ssh-agent
+	AGENT
+	// 使用密码回答服务端的问题
+	KEYBOARD_INTERACTIVE
 )
 
 type Task struct {
@@ -26,8 +32,31 @@ type Task struct {
 	Pass    string
 	Command []string
 
+	// 按顺序尝试的认证方式, 为空时只使用SSHType
+	AuthMethods []int
+	// 其它私钥, 与KeyPath一起尝试
+	KeyPaths []string
+	// 私钥的passphrase
+	Passphrase string
+
 	// 设置ssh的超时时间
 	Timeout time.Duration
+	// 单条命令的超时时间, 超时后向远程进程发送KILL信号
+	CommandTimeout time.Duration
+	// 整个任务(连接和所有命令)的超时时间
+	TaskTimeout time.Duration
+
+	// 主机公钥校验策略, 为0时沿用PlayBook的设置
+	HostKeyPolicy int
+	// known_hosts文件路径, 为空时沿用PlayBook的设置, 默认 ~/.ssh/known_hosts
+	KnownHosts string
+
+	// 跳板机列表, 按顺序经过, 格式为 [user@]host[:port]
+	// 未指定用户时使用 User, 端口默认22, 认证方式与目标主机相同
+	ProxyJump []string
+
+	// 文件传输, 按顺序在命令之前执行, 失败时不再执行命令
+	Copies []CopyStep
 }
 
 type PlayBook struct {
@@ -35,414 +64,292 @@ type PlayBook struct {
 	name string
 	// 并行任务数量
 	g *gate.Gate
+	// 新建连接的速率, 每个网段和每个跳板机的并发, 全局并发使用g
+	limits *gate.Limits
 	// 要执行的任务
 	m []*Task
+
+	// 默认的主机公钥校验策略和known_hosts文件
+	hostKeyPolicy int
+	knownHosts    string
+
+	// Run 和 FetchInfo 的输出, 默认为标准输出
+	out io.Writer
+
+	// 分批执行策略
+	strategy Strategy
 }
 
+// New 创建PlayBook, pNum 为同时执行的主机数量, 小于1时为1
 func New(playbookname string, pNum int) *PlayBook {
 
-	var g *gate.Gate
-	if pNum > 0 && pNum < 20 {
-		g = gate.New(pNum)
-	} else {
-		g = gate.New(1)
-	}
-
+	g := gate.New(pNum)
 	return &PlayBook{
-		name: playbookname,
-		g:    g,
-		m:    make([]*Task, 0),
+		name:   playbookname,
+		g:      g,
+		limits: gate.NewLimits(g, nil, gate.Limit{}, gate.Limit{}),
+		m:      make([]*Task, 0),
+		out:    os.Stdout,
 	}
 }
 
-func (p *PlayBook) AddTask(name string, t Task) {
+// PlayBook名称
+func (p *PlayBook) Name() string {
+	return p.name
+}
+
+// 添加任务, name 必须与PlayBook名称一致
+func (p *PlayBook) AddTask(name string, t Task) error {
+
+	if name != p.name {
+		return fmt.Errorf("kwssh: task for playbook [%s] can not be added to playbook [%s]", name, p.name)
+	}
 
 	task := new(Task)
 
 	task.Command = t.Command
 	task.IP = t.IP
 	task.KeyPath = t.KeyPath
+	task.KeyPaths = t.KeyPaths
+	task.AuthMethods = t.AuthMethods
+	task.Passphrase = t.Passphrase
 	task.Pass = t.Pass
 	task.Port = t.Port
 	task.SSHType = t.SSHType
 	task.Timeout = t.Timeout
+	task.CommandTimeout = t.CommandTimeout
+	task.TaskTimeout = t.TaskTimeout
 	task.User = t.User
+	task.HostKeyPolicy = t.HostKeyPolicy
+	task.KnownHosts = t.KnownHosts
+	task.ProxyJump = t.ProxyJump
+	task.Copies = t.Copies
 
-	if name == p.name {
-		p.m = append(p.m, task)
-	}
+	p.m = append(p.m, task)
+	return nil
 }
 
-// 执行命令，将命令结果推送到channel
-func (p *PlayBook) exec() {
-
-	var wg sync.WaitGroup
-
-	for _, v := range p.m {
-		wg.Add(1)
-		go func(v *Task) {
-			defer func() {
-				wg.Done()
-				p.g.Leave()
-			}()
+// 调整同时执行的主机数量, 执行过程中调整也会生效
+func (p *PlayBook) SetParallel(n int) {
+	p.g.Resize(n)
+}
 
-			p.g.Enter()
+// 设置每秒新建连接的数量(rate为0时不限制), 以及每个/24网段和每个跳板机的限制
+// 避免同时连接一个机柜的所有主机时打满交换机或触发sshd的MaxStartups
+func (p *PlayBook) SetLimits(rate float64, burst int, subnet, jump gate.Limit) {
+	p.limits = gate.NewLimits(p.g, gate.NewLimiter(rate, burst), subnet, jump)
+}
 
-			cli := SSH{}
-			err := cli.NewClient(v)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+// 正在执行和等待执行的主机数量
+func (p *PlayBook) Progress() (running, waiting int) {
+	return p.g.InFlight(), p.g.Waiting()
+}
 
-			_, err = cli.RunCommands(v.Command)
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+// 设置分批执行策略
+func (p *PlayBook) SetStrategy(s Strategy) {
+	p.strategy = s
+}
 
-		}(v)
-	}
+// 设置 Run 和 FetchInfo 的输出
+func (p *PlayBook) SetOutput(w io.Writer) {
+	p.out = w
+}
 
-	wg.Wait()
-	close(resChan)
+// 设置默认的主机公钥校验策略, 对未单独指定策略的Task生效
+func (p *PlayBook) SetHostKeyPolicy(policy int, knownHosts string) {
+	p.hostKeyPolicy = policy
+	p.knownHosts = knownHosts
+}
 
+// Exec 执行所有任务, 每台主机的结果推送到返回的channel
+// 所有任务结束后channel关闭, 每次调用都使用独立的channel, 可以多次或并发执行
+// ctx 取消后未开始的主机直接返回错误, 正在执行的命令会被终止
+func (p *PlayBook) Exec(ctx context.Context) <-chan CommandResult {
+	return p.exec(ctx, execTask)
 }
 
-// 从channel中读取执行结果，并展示
+// 连接成功后在一台主机上执行的操作
+type taskFunc func(ctx context.Context, cli *SSH, t *Task) CommandResult
 
-func (p *PlayBook) Run() {
-	var wg sync.WaitGroup
+// 在每台主机上执行run，将结果推送到channel
+// 按 Strategy 分批执行, 失败过多时剩余的主机直接推送 ErrAborted
+func (p *PlayBook) exec(ctx context.Context, run taskFunc) <-chan CommandResult {
 
-	go p.exec()
-	wg.Add(1)
+	resChan := make(chan CommandResult, len(p.m))
+	// 同一次执行中经过相同跳板机的任务复用跳板机连接
+	jumps := newJumpPool()
+	strategy := p.strategy
 
-	// 读取结果并输出
 	go func() {
-		defer wg.Done()
-		for res := range resChan {
-			for _, v := range res.res {
-				fmt.Printf("IP: [%s], User: [%s], Command: [%s]\nCommand Output:\n%s\n", res.ip, res.user, v.cmd, strings.TrimLeft(string(v.data), " "))
+		defer func() {
+			jumps.Close()
+			close(resChan)
+		}()
+
+		start, failed := 0, 0
+		for _, size := range strategy.batches(len(p.m)) {
+			batch := p.m[start : start+size]
+			start += size
+
+			// ctx 取消时由runTask返回ctx的错误
+			if ctx.Err() == nil && strategy.exceeded(failed) {
+				for _, v := range batch {
+					resChan <- CommandResult{IP: v.IP, User: v.User, Err: ErrAborted}
+				}
+				continue
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			for _, v := range batch {
+				wg.Add(1)
+				go func(v *Task) {
+					defer wg.Done()
+
+					res := p.runTask(ctx, v, jumps, run)
+					if !res.OK() {
+						mu.Lock()
+						failed++
+						mu.Unlock()
+					}
+					resChan <- res
+				}(v)
 			}
+			wg.Wait()
 		}
 	}()
 
-	wg.Wait()
+	return resChan
 }
 
-func (p *PlayBook) FetchInfo() {
-	var wg sync.WaitGroup
-
-	for _, v := range p.m {
-		v.Command = []string{
-			productName,
-			sn,
-			cpuName,
-			cpuCoreNum,
-			memTotal,
-			osName,
-			kernelVersion,
-			diskInfo,
-			raidInfo,
-			mems,
-			pwrsupplies,
-		}
+// 连接一台主机并执行run
+func (p *PlayBook) runTask(ctx context.Context, v *Task, jumps *jumpPool, run taskFunc) CommandResult {
+	t := *v
+	// ctx 取消后不再等待
+	release, err := p.limits.Acquire(ctx, t.IP, strings.Join(t.ProxyJump, ","))
+	if err != nil {
+		return CommandResult{IP: t.IP, User: t.User, Err: err}
 	}
+	defer release()
 
-	go p.exec()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		// 读取命令结果写入到标准输出
-		for res := range resChan {
-			detail := machineDetail{}
-			detail.ip = res.ip
-
-			// 获取机器基本信息
-			for k, v := range res.res {
-				if k == 0 {
-					// 处理机器型号
-					detail.productName = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-
-					if len(detail.productName) == 0 {
-						detail.productName = "无权限查看"
-					}
-				}
+	taskCtx, cancel := withTimeout(ctx, t.TaskTimeout)
+	defer cancel()
 
-				if k == 1 {
-					// 处理机器SN
-					detail.sn = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					if len(detail.sn) == 0 {
-						detail.sn = "无权限查看"
-					}
-				}
-
-				if k == 2 {
-					// 处理CPU信息
-					detail.cpu.cpuname = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-
-				if k == 3 {
-					// 处理CPU数量
-					detail.cpu.cpuCoreNum = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					detail.cpu.fullName = detail.cpu.cpuname + " x " + detail.cpu.cpuCoreNum
-				}
-
-				if k == 4 {
-					// 处理内存信息
-					detail.memTotal = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					f, err := strconv.ParseFloat(detail.memTotal, 64)
-					if err == nil {
-						detail.memTotal = strconv.Itoa(int(f / 1024))
-					}
-				}
-
-				if k == 5 {
-					// 操作系统信息 etc.. CentOS Ubuntu
-					detail.osName = strings.Trim(strings.Trim(string(v.data), "\t"), "\n")
-				}
-
-				if k == 6 {
-					// 内核版本
-					detail.kernelVersion = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-
-				if k == 7 {
-					// 磁盘信息
-					detail.hardDisks = parseDiskInfo(string(v.data))
-				}
-
-				if k == 8 {
-					// raid信息
-					detail.raids = parseRaidInfo(string(v.data))
-				}
-
-				if k == 9 {
-					// 内存位置信息
-					detail.mems = parseMemInfo(string(v.data))
-				}
-
-				if k == 10 {
-					// 电源信息
-					detail.power = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-			}
-
-			fmt.Printf("%-9s:\t%s\n%-7s:\t[%s]\n%-6s:\t[%s]\n%-5s:\t[%s]\n%-5s:\t[%s]\n%-9s:\t[%s]\n%-7s:\t[%s MB]\n",
-				"IP", detail.ip, "型号", detail.productName, "序列号", detail.sn, "操作系统", detail.osName, "内核版本", detail.kernelVersion, "CPU", detail.cpu.fullName,
-				"内存", detail.memTotal)
-
-			if len(detail.power) != 0 {
-				fmt.Printf("%-5s:\t[%s]\n", "电源模块", detail.power)
-			}
-
-			if len(detail.mems) != 0 {
-				fmt.Println("内存位置信息 :")
-				for _, v := range detail.mems {
-					fmt.Printf("\t内存位置: [%s] 内存类型: [%s] 内存容量: [%s]\n", v.location, v.memType, v.size)
-				}
-			}
-
-			if len(detail.hardDisks) != 0 {
-				fmt.Println("磁盘信息 :")
-				for _, v := range detail.hardDisks {
-					fmt.Printf("\t磁盘: [%s] 容量: [%s] 介质: [%s]\n", v.product, v.capacity, v.media)
-				}
-			}
-
-			if len(detail.raids) != 0 {
-				fmt.Println("RAID信息 :")
-				for _, v := range detail.raids {
-					fmt.Printf("\tRAID Level: [%s] 容量: [%s]\n", v.raidLevel, v.size)
-				}
-			}
-
-			fmt.Println()
-		}
+	if t.HostKeyPolicy == 0 {
+		t.HostKeyPolicy = p.hostKeyPolicy
+	}
+	if t.KnownHosts == "" {
+		t.KnownHosts = p.knownHosts
+	}
 
-	}()
+	cli := SSH{jumps: jumps}
+	err = cli.NewClient(taskCtx, &t)
+	if err != nil {
+		// 连接失败也推送结果, 方便汇总失败的主机
+		return CommandResult{IP: t.IP, User: t.User, Err: err}
+	}
 
-	wg.Wait()
+	return run(taskCtx, &cli, &t)
 }
 
-func (p *PlayBook) FetchInfoToDB() {
-	var wg sync.WaitGroup
+// 执行Task中的文件传输和命令
+func execTask(ctx context.Context, cli *SSH, t *Task) CommandResult {
+	var copies []CopyOutput
+	if len(t.Copies) != 0 {
+		copies = cli.Copy(ctx, t.Copies)
+		failed := false
+		for _, v := range copies {
+			failed = failed || !v.OK()
+		}
+		// 只传输文件, 或传输失败时不再执行命令
+		if failed || len(t.Command) == 0 {
+			cli.Close()
+			return CommandResult{IP: t.IP, User: t.User, Copies: copies}
+		}
+	}
 
-	// 初始化数据库
-	err := db.Init()
+	res, err := cli.RunCommands(ctx, t.Command)
 	if err != nil {
-		fmt.Printf("初始化数据库失败, err=%#v", err)
-		return
+		cli.Close()
+		return CommandResult{IP: t.IP, User: t.User, Copies: copies, Err: err}
 	}
+	res.Copies = copies
+	return res
+}
 
-	defer db.Close()
-
-	for _, v := range p.m {
-		v.Command = []string{
-			productName,
-			sn,
-			cpuName,
-			cpuCoreNum,
-			memTotal,
-			osName,
-			kernelVersion,
-			diskInfo,
-			raidInfo,
-			mems,
-			pwrsupplies,
+// 主机失败原因, 成功时为空
+func failReason(res CommandResult) string {
+	var hkErr *HostKeyError
+	if errors.As(res.Err, &hkErr) {
+		return fmt.Sprintf("主机公钥校验失败, 可能存在中间人攻击: %s", hkErr.Err)
+	}
+	if res.Err != nil {
+		return res.Err.Error()
+	}
+	for _, v := range res.Copies {
+		if !v.OK() {
+			return fmt.Sprintf("copy [%s]: %s", v.Src, v.Err)
 		}
 	}
+	for _, v := range res.Res {
+		if !v.OK() {
+			return fmt.Sprintf("command [%s]: %s", v.Cmd, v.Status())
+		}
+	}
+	return ""
+}
 
-	go p.exec()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		// 读取命令结果写入到数据库
-		for res := range resChan {
-			detail := machineDetail{}
-			detail.ip = res.ip
-
-			// 获取机器基本信息
-			for k, v := range res.res {
-				if k == 0 {
-					// 处理机器型号
-					detail.productName = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-
-					if len(detail.productName) == 0 {
-						detail.productName = "无权限查看"
-					}
-				}
-
-				if k == 1 {
-					// 处理机器SN
-					detail.sn = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					if len(detail.sn) == 0 {
-						detail.sn = "无权限查看"
-					}
-				}
-
-				if k == 2 {
-					// 处理CPU信息
-					detail.cpu.cpuname = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-
-				if k == 3 {
-					// 处理CPU数量
-					detail.cpu.cpuCoreNum = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					detail.cpu.fullName = detail.cpu.cpuname + " x " + detail.cpu.cpuCoreNum
-				}
-
-				if k == 4 {
-					// 处理内存信息
-					detail.memTotal = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-					f, err := strconv.ParseFloat(detail.memTotal, 64)
-					if err == nil {
-						detail.memTotal = strconv.Itoa(int(f / 1024))
-					}
-				}
-
-				if k == 5 {
-					// 操作系统信息 etc.. CentOS Ubuntu
-					detail.osName = strings.Trim(strings.Trim(string(v.data), "\t"), "\n")
-				}
-
-				if k == 6 {
-					// 内核版本
-					detail.kernelVersion = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-
-				if k == 7 {
-					// 磁盘信息
-					detail.hardDisks = parseDiskInfo(string(v.data))
-				}
-
-				if k == 8 {
-					// raid信息
-					detail.raids = parseRaidInfo(string(v.data))
-				}
-
-				if k == 9 {
-					// 内存位置信息
-					detail.mems = parseMemInfo(string(v.data))
-				}
-
-				if k == 10 {
-					// 电源信息
-					detail.power = strings.Trim(strings.TrimLeft(string(v.data), " "), "\n")
-				}
-			}
-
-			// 组装数据到db
-			info := db.Machine_INFO{}
-
-			info.Disks = make([]db.Machine_Disk_INFO_MODEL, 0)
-			info.Raids = make([]db.Machine_RAID_INFO_MODEL, 0)
-			info.Memorys = make([]db.Machine_Memory_INFO_MODEL, 0)
-
-			info.Base.Cpu = detail.cpu.fullName
-			info.Base.IP = detail.ip
-			info.Base.KernelVersion = detail.kernelVersion
-			info.Base.Memory = detail.memTotal + " MB"
-			info.Base.Model = detail.productName
-			info.Base.OS = detail.osName
-			info.Base.SN = detail.sn
-
-			if len(detail.power) != 0 {
-				info.Base.Power = detail.power
-			}
-
-			if len(detail.mems) != 0 {
-
-				for _, v := range detail.mems {
-
-					// 组装内存信息
-					info.Memorys = append(info.Memorys, db.Machine_Memory_INFO_MODEL{
-						SN:       info.Base.SN,
-						Size:     v.size,
-						Type:     v.memType,
-						Location: v.location,
-					})
-				}
-			}
-
-			if len(detail.hardDisks) != 0 {
-				for _, v := range detail.hardDisks {
+// 从channel中读取执行结果，并展示
+// 有任意主机失败时返回错误
+func (p *PlayBook) Run(ctx context.Context) error {
+	var ok, failed []CommandResult
 
-					// 组装disk信息 结构体
-					info.Disks = append(info.Disks, db.Machine_Disk_INFO_MODEL{
-						SN:       info.Base.SN,
-						Media:    v.media,
-						Capacity: v.capacity,
-						Product:  v.product,
-					})
-				}
+	// 读取结果并输出
+	for res := range p.Exec(ctx) {
+		for _, v := range res.Copies {
+			fmt.Fprintf(p.out, "IP: [%s], User: [%s], Copy: [%s -> %s], Status: [%s], Files: [%d], Skipped: [%d], Bytes: [%d], Duration: [%s]\n",
+				res.IP, res.User, v.Src, v.Dest, copyStatusText(v), v.Files, v.Skipped, v.Bytes, v.Duration.Round(time.Millisecond))
+		}
+		for _, v := range res.Res {
+			fmt.Fprintf(p.out, "IP: [%s], User: [%s], Command: [%s], Status: [%s], Duration: [%s]\nCommand Output:\n%s\n",
+				res.IP, res.User, v.Cmd, statusText(v), v.Duration.Round(time.Millisecond), strings.TrimLeft(string(v.Stdout), " "))
+			if len(v.Stderr) != 0 {
+				fmt.Fprintf(p.out, "Command Stderr:\n%s\n", strings.TrimLeft(string(v.Stderr), " "))
 			}
+		}
 
-			if len(detail.raids) != 0 {
-				for _, v := range detail.raids {
-
-					// 组装raid信息 结构体
-					info.Raids = append(info.Raids, db.Machine_RAID_INFO_MODEL{
-						SN:       info.Base.SN,
-						Level:    v.raidLevel,
-						Capacity: v.size,
-					})
-				}
-			}
+		if res.OK() {
+			ok = append(ok, res)
+		} else {
+			failed = append(failed, res)
+		}
+	}
 
-			err := db.WriteToDB(info)
-			if err != nil {
-				fmt.Printf("db.WriteToDB(info) err, err=%#v", err)
-				continue
-			}
+	fmt.Fprintln(p.out, "执行结果汇总:")
+	for _, res := range ok {
+		fmt.Fprintf(p.out, "\t[成功] %s\n", res.IP)
+	}
+	for _, res := range failed {
+		fmt.Fprintf(p.out, "\t[失败] %s: %s\n", res.IP, failReason(res))
+	}
+	fmt.Fprintf(p.out, "成功: %d, 失败: %d\n", len(ok), len(failed))
 
-		}
+	if len(failed) != 0 {
+		return fmt.Errorf("kwssh: playbook [%s]: %d/%d hosts failed", p.name, len(failed), len(ok)+len(failed))
+	}
+	return nil
+}
 
-	}()
+func statusText(o CommandOutput) string {
+	if o.OK() {
+		return "success"
+	}
+	return o.Status()
+}
 
-	wg.Wait()
+func copyStatusText(o CopyOutput) string {
+	if o.OK() {
+		return "success"
+	}
+	return o.Err.Error()
 }
@@ -0,0 +1,253 @@
+package kwssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zeus/gate"
+	"zeus/kwssh/sshtest"
+)
+
+func newTestPlayBook(t *testing.T, s *sshtest.Server, name string, hosts int, cmds ...string) *PlayBook {
+	t.Helper()
+
+	pb := New(name, 3)
+	pb.SetOutput(io.Discard)
+	for i := 0; i < hosts; i++ {
+		if err := pb.AddTask(name, testTask(s, cmds...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pb
+}
+
+func collect(t *testing.T, pb *PlayBook, want string) {
+	t.Helper()
+
+	n := 0
+	for res := range pb.Exec(context.Background()) {
+		n++
+		if !res.OK() {
+			t.Errorf("playbook [%s]: host %s failed: %s", pb.Name(), res.IP, failReason(res))
+			continue
+		}
+		if got := string(res.Res[0].Stdout); got != want {
+			t.Errorf("playbook [%s]: stdout = %q, want %q", pb.Name(), got, want)
+		}
+	}
+	if n != len(pb.m) {
+		t.Errorf("playbook [%s]: got %d results, want %d", pb.Name(), n, len(pb.m))
+	}
+}
+
+func TestAddTaskName(t *testing.T) {
+	pb := New("p1", 1)
+	if err := pb.AddTask("p2", Task{IP: "10.0.0.1"}); err == nil {
+		t.Fatal("AddTask with another playbook name should fail")
+	}
+}
+
+func TestPlayBookExecTwice(t *testing.T) {
+	s := newServer(t, sshtest.Config{Default: &sshtest.Response{Stdout: "up"}})
+	pb := newTestPlayBook(t, s, "twice", 4, "uptime")
+
+	collect(t, pb, "up")
+	collect(t, pb, "up")
+
+	if err := pb.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := pb.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlayBooksConcurrent(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"hostname": {Stdout: "web01"},
+			"uname -r": {Stdout: "5.10.0"},
+		},
+	})
+	pb1 := newTestPlayBook(t, s, "p1", 5, "hostname")
+	pb2 := newTestPlayBook(t, s, "p2", 5, "uname -r")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			collect(t, pb1, "web01")
+		}()
+		go func() {
+			defer wg.Done()
+			collect(t, pb2, "5.10.0")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPlayBookRunSummary(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"ok":   {Stdout: "fine"},
+			"fail": {Stderr: "nope", ExitCode: 1},
+		},
+	})
+
+	var out bytes.Buffer
+	pb := New("summary", 2)
+	pb.SetOutput(&out)
+	pb.AddTask("summary", testTask(s, "ok"))
+	pb.AddTask("summary", testTask(s, "ok", "fail"))
+
+	bad := testTask(s, "ok")
+	bad.Pass = "wrong"
+	pb.AddTask("summary", bad)
+
+	if err := pb.Run(context.Background()); err == nil {
+		t.Fatal("Run should report failed hosts")
+	}
+	for _, want := range []string{"成功: 1, 失败: 2", "command [fail]: exit code 1", "Command Stderr:\nnope"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestFetchInfo(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			productName:   {Stdout: " PowerEdge R740\n"},
+			sn:            {Stdout: "7XK2N33\n"},
+			cpuName:       {Stdout: " Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz\n"},
+			cpuCoreNum:    {Stdout: "2\n"},
+			memTotal:      {Stdout: "32768000\n"},
+			osName:        {Stdout: "CentOS Linux 7 (Core)\n"},
+			kernelVersion: {Stdout: "3.10.0-1160.el7.x86_64\n"},
+			diskInfo:      {Stdout: readFixture(t, "omreport_pdisk.txt")},
+			raidInfo:      {Stdout: readFixture(t, "omreport_vdisk.txt")},
+			mems:          {Stdout: readFixture(t, "omreport_memory.txt")},
+			pwrsupplies:   {Stdout: " 750 W\n"},
+		},
+	})
+
+	var out bytes.Buffer
+	pb := newTestPlayBook(t, s, "fetch", 1, "uptime")
+	pb.SetOutput(&out)
+	if err := pb.FetchInfo(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"[PowerEdge R740]",
+		"[7XK2N33]",
+		"[Intel(R) Xeon(R) Gold 6230 CPU @ 2.10GHz x 2]",
+		"[32000 MB]",
+		"[3.10.0-1160.el7.x86_64]",
+		"内存位置: [A2] 内存类型: [DDR4 - Synchronous Registered (Buffered)] 内存容量: [16384 MB]",
+		"磁盘: [MZ7KH1T9HAJR0D3] 容量: [1787.88 GB] 介质: [SSD]",
+		"RAID Level: [RAID-5] 容量: [3575.75 GB]",
+		"[750 W]",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("FetchInfo output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// FetchInfo 不应修改Task中的命令
+	for res := range pb.Exec(context.Background()) {
+		if len(res.Res) != 1 || res.Res[0].Cmd != "uptime" {
+			t.Fatalf("task commands changed after FetchInfo: %+v", res.Res)
+		}
+	}
+
+	// 连接失败的主机
+	down := newServer(t, sshtest.Config{})
+	down.Close()
+	pb.AddTask("fetch", testTask(down))
+	if err := pb.FetchInfo(context.Background()); err == nil || !strings.Contains(err.Error(), "1/2 hosts failed") {
+		t.Errorf("FetchInfo err = %v, want 1/2 hosts failed", err)
+	}
+
+	// 输出失败时采集成功的主机也计为失败
+	pb.SetOutput(failWriter{})
+	if err := pb.FetchInfo(context.Background()); !errors.Is(err, io.ErrClosedPipe) || !strings.Contains(err.Error(), "2/2 hosts failed") {
+		t.Errorf("FetchInfo err = %v, want 2/2 hosts failed and %v", err, io.ErrClosedPipe)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestPlayBookRunCancel(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"fast": {Stdout: "done"},
+			"hang": {Delay: 10 * time.Second},
+		},
+	})
+
+	var out bytes.Buffer
+	pb := New("cancel", 5)
+	pb.SetOutput(&out)
+	pb.AddTask("cancel", testTask(s, "fast"))
+	pb.AddTask("cancel", testTask(s, "hang"))
+	pb.AddTask("cancel", testTask(s, "hang"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := pb.Run(ctx); err == nil {
+		t.Fatal("Run should fail after cancel")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Run did not return after cancel")
+	}
+	if !strings.Contains(out.String(), "成功: 1, 失败: 2") {
+		t.Errorf("completed host missing from summary:\n%s", out.String())
+	}
+}
+
+func TestPlayBookTaskTimeout(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"hang": {Delay: 10 * time.Second},
+		},
+	})
+
+	pb := New("deadline", 1)
+	task := testTask(s, "hang", "hang")
+	task.TaskTimeout = 100 * time.Millisecond
+	pb.AddTask("deadline", task)
+
+	for res := range pb.Exec(context.Background()) {
+		if !errors.Is(res.Err, context.DeadlineExceeded) || len(res.Res) != 1 || !res.Res[0].TimedOut {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+	}
+}
+
+func TestPlayBookRateLimit(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{"uptime": {Stdout: "up\n"}},
+	})
+
+	pb := newTestPlayBook(t, s, "rate", 5, "uptime")
+	pb.SetLimits(50, 1, gate.Limit{}, gate.Limit{})
+
+	start := time.Now()
+	collect(t, pb, "up\n")
+	// 第一个连接立即开始, 后面4个每20ms一个
+	if d := time.Since(start); d < 75*time.Millisecond {
+		t.Fatalf("5 connections at 50/s took %v", d)
+	}
+}
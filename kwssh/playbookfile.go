@@ -0,0 +1,355 @@
+package kwssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+playbook 文件格式(YAML, JSON 同样支持):
+
+	credentials:
+	  ops:
+	    user: root
+	    port: 22
+	    key: /root/.ssh/id_rsa
+	    # passphrase_env: KWSSH_PASSPHRASE
+	    # password: xxx
+	    # password_env: IDC_PASS
+	    # 按顺序尝试的认证方式: agent, publickey, password, keyboard-interactive
+	    # auth: [agent, publickey]
+	plays:
+	  - name: uptime
+	    hosts: [10.0.0.1, 10.0.0.2]
+	    credential: ops
+	    commands:
+	      - uptime
+	      - df -h
+	    parallel: 5
+	    timeout: 10s
+	    command_timeout: 5m
+	    task_timeout: 30m
+	    host_key: strict
+	    # 按顺序经过的跳板机, [user@]host[:port]
+	    # jump: [ops@10.0.255.1:22]
+	    # 分批执行: 每批主机数量或百分比, 先在一台主机上执行, 累计失败超过max_failures台时中止(默认0)
+	    # batch: 20%
+	    # canary: true
+	    # max_failures: 0
+*/
+
+type credentialSpec struct {
+	User        string `yaml:"user"`
+	Port        int32  `yaml:"port"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"password_env"`
+	Key         string `yaml:"key"`
+	// 私钥passphrase所在的环境变量
+	PassphraseEnv string   `yaml:"passphrase_env"`
+	Auth          []string `yaml:"auth"`
+}
+
+type playSpec struct {
+	Name        string   `yaml:"name"`
+	Hosts       []string `yaml:"hosts"`
+	Credential  string   `yaml:"credential"`
+	Commands    []string `yaml:"commands"`
+	Parallel    int      `yaml:"parallel"`
+	Timeout     string   `yaml:"timeout"`
+	CmdTimeout  string   `yaml:"command_timeout"`
+	TaskTimeout string   `yaml:"task_timeout"`
+	HostKey     string   `yaml:"host_key"`
+	KnownHosts  string   `yaml:"known_hosts"`
+	Jump        []string `yaml:"jump"`
+	Batch       string   `yaml:"batch"`
+	Canary      bool     `yaml:"canary"`
+	MaxFailures *int     `yaml:"max_failures"`
+}
+
+type playBookFile struct {
+	Credentials map[string]credentialSpec `yaml:"credentials"`
+	Plays       []playSpec                `yaml:"plays"`
+}
+
+// FileError playbook文件校验错误, 带文件名和行号
+type FileError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *FileError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+var (
+	yamlLineRe    = regexp.MustCompile(`^line (\d+): (.*)$`)
+	yamlUnknownRe = regexp.MustCompile(`^field (\S+) not found in type .*$`)
+)
+
+// LoadPlayBooks 读取并校验playbook文件, 每个play生成一个PlayBook
+// 所有校验都在连接主机之前完成, 有任意错误则不返回PlayBook
+func LoadPlayBooks(path string) ([]*PlayBook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("kwssh: read playbook [%s] failed, err=%#v", path, err.Error())
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Join(yamlErrors(path, err)...)
+	}
+
+	// 未知的配置项和类型错误不影响其它字段的解析, 与后面的校验错误一起返回
+	var errs []error
+	f := playBookFile{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, errors.Join(yamlErrors(path, err)...)
+		}
+		errs = append(errs, yamlErrors(path, err)...)
+	}
+
+	report := func(line int, format string, a ...interface{}) {
+		errs = append(errs, &FileError{File: path, Line: line, Msg: fmt.Sprintf(format, a...)})
+	}
+
+	if len(f.Plays) == 0 {
+		report(lineOf(&root, "plays"), "plays 不能为空")
+	}
+
+	credNames := make([]string, 0, len(f.Credentials))
+	for name := range f.Credentials {
+		credNames = append(credNames, name)
+	}
+	sort.Strings(credNames)
+
+	for _, name := range credNames {
+		c := f.Credentials[name]
+		line := lineOf(&root, "credentials", name)
+		if c.User == "" {
+			report(line, "credential %q: user 不能为空", name)
+		}
+		if c.Key == "" && c.Password == "" && c.PasswordEnv == "" && len(c.Auth) == 0 {
+			report(line, "credential %q: 请指定 password, password_env, key 或 auth", name)
+		}
+		if c.PasswordEnv != "" && os.Getenv(c.PasswordEnv) == "" {
+			report(lineOf(&root, "credentials", name, "password_env"), "credential %q: 环境变量 %s 为空", name, c.PasswordEnv)
+		}
+		if c.PassphraseEnv != "" && os.Getenv(c.PassphraseEnv) == "" {
+			report(lineOf(&root, "credentials", name, "passphrase_env"), "credential %q: 环境变量 %s 为空", name, c.PassphraseEnv)
+		}
+		if _, err := ParseAuthMethods(strings.Join(c.Auth, ",")); err != nil {
+			report(lineOf(&root, "credentials", name, "auth"), "credential %q: %s", name, err)
+		}
+	}
+
+	names := map[string]bool{}
+	pbs := make([]*PlayBook, 0, len(f.Plays))
+
+	for i, play := range f.Plays {
+		idx := strconv.Itoa(i)
+		line := lineOf(&root, "plays", idx)
+
+		if play.Name == "" {
+			report(line, "plays[%d]: name 不能为空", i)
+		} else if names[play.Name] {
+			report(lineOf(&root, "plays", idx, "name"), "play %q: name 重复", play.Name)
+		}
+		names[play.Name] = true
+
+		if len(play.Hosts) == 0 {
+			report(line, "play %q: hosts 不能为空", play.Name)
+		}
+		for j, h := range play.Hosts {
+			if h == "" {
+				report(lineOf(&root, "plays", idx, "hosts", strconv.Itoa(j)), "play %q: host 不能为空", play.Name)
+			}
+		}
+
+		if len(play.Commands) == 0 {
+			report(line, "play %q: commands 不能为空", play.Name)
+		}
+		for j, c := range play.Commands {
+			if c == "" {
+				report(lineOf(&root, "plays", idx, "commands", strconv.Itoa(j)), "play %q: command 不能为空", play.Name)
+			}
+		}
+
+		cred, ok := f.Credentials[play.Credential]
+		if play.Credential == "" {
+			report(line, "play %q: credential 不能为空", play.Name)
+		} else if !ok {
+			report(lineOf(&root, "plays", idx, "credential"), "play %q: 未定义的 credential %q", play.Name, play.Credential)
+		}
+
+		parseDuration := func(key, v string) time.Duration {
+			if v == "" {
+				return 0
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				report(lineOf(&root, "plays", idx, key), "play %q: %s 格式错误 %q", play.Name, key, v)
+			}
+			return d
+		}
+		timeout := parseDuration("timeout", play.Timeout)
+		cmdTimeout := parseDuration("command_timeout", play.CmdTimeout)
+		taskTimeout := parseDuration("task_timeout", play.TaskTimeout)
+
+		policy, err := ParseHostKeyPolicy(play.HostKey)
+		if err != nil {
+			report(lineOf(&root, "plays", idx, "host_key"), "play %q: %s", play.Name, err)
+		}
+		for i, hop := range play.Jump {
+			if _, _, err := parseJump(hop, cred.User); err != nil {
+				report(lineOf(&root, "plays", idx, "jump", strconv.Itoa(i)), "play %q: 跳板机格式错误 %q", play.Name, hop)
+			}
+		}
+
+		batchSize, batchPercent, err := ParseBatch(play.Batch)
+		if err != nil {
+			report(lineOf(&root, "plays", idx, "batch"), "play %q: batch 格式错误 %q", play.Name, play.Batch)
+		}
+
+		if len(errs) > 0 {
+			continue
+		}
+
+		parallel := play.Parallel
+		if parallel == 0 {
+			parallel = 5
+		}
+
+		pb := New(play.Name, parallel)
+
+		strategy := Strategy{BatchSize: batchSize, BatchPercent: batchPercent, Canary: play.Canary}
+		if play.MaxFailures != nil {
+			strategy.MaxFailures = *play.MaxFailures
+		}
+		pb.SetStrategy(strategy)
+
+		task := Task{
+			Port:           cred.Port,
+			User:           cred.User,
+			Command:        play.Commands,
+			Timeout:        timeout,
+			CommandTimeout: cmdTimeout,
+			TaskTimeout:    taskTimeout,
+			HostKeyPolicy:  policy,
+			KnownHosts:     play.KnownHosts,
+			ProxyJump:      play.Jump,
+		}
+		if task.Port == 0 {
+			task.Port = 22
+		}
+		if cred.Key != "" {
+			task.SSHType = PUBLICKEY
+			task.KeyPath = cred.Key
+		}
+		if cred.Password != "" || cred.PasswordEnv != "" {
+			task.SSHType = PASSWORD
+			task.Pass = cred.Password
+			if cred.PasswordEnv != "" {
+				task.Pass = os.Getenv(cred.PasswordEnv)
+			}
+		}
+		if cred.PassphraseEnv != "" {
+			task.Passphrase = os.Getenv(cred.PassphraseEnv)
+		}
+		task.AuthMethods, _ = ParseAuthMethods(strings.Join(cred.Auth, ","))
+
+		for _, h := range play.Hosts {
+			task.IP = h
+			if err := pb.AddTask(play.Name, task); err != nil {
+				return nil, err
+			}
+		}
+		pbs = append(pbs, pb)
+	}
+
+	if len(errs) > 0 {
+		// 按行号输出
+		sort.SliceStable(errs, func(i, j int) bool {
+			return errs[i].(*FileError).Line < errs[j].(*FileError).Line
+		})
+		return nil, errors.Join(errs...)
+	}
+
+	return pbs, nil
+}
+
+// 把yaml库的错误转换为 file:line 格式
+func yamlErrors(path string, err error) []error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		fe := &FileError{File: path, Msg: err.Error()}
+		if m := yamlLineRe.FindStringSubmatch(strings.TrimPrefix(err.Error(), "yaml: ")); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Msg = m[2]
+		}
+		return []error{fe}
+	}
+
+	errs := make([]error, 0, len(typeErr.Errors))
+	for _, e := range typeErr.Errors {
+		fe := &FileError{File: path, Msg: e}
+		if m := yamlLineRe.FindStringSubmatch(e); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Msg = yamlUnknownRe.ReplaceAllString(m[2], "未知的配置项 $1")
+		}
+		errs = append(errs, fe)
+	}
+	return errs
+}
+
+// 按路径查找节点所在行, mapping 用 key 查找, sequence 用下标查找
+// 找不到时返回最后一个找到的节点所在行
+func lineOf(n *yaml.Node, path ...string) int {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	line := n.Line
+	for _, p := range path {
+		var next *yaml.Node
+
+		switch n.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					line = n.Content[i].Line
+					next = n.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(p); err == nil && i < len(n.Content) {
+				next = n.Content[i]
+				line = next.Line
+			}
+		}
+
+		if next == nil {
+			return line
+		}
+		n = next
+	}
+	return line
+}
@@ -0,0 +1,168 @@
+package kwssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePlayBook(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPlayBooks(t *testing.T) {
+	t.Setenv("KWSSH_TEST_PASS", "secret")
+
+	path := writePlayBook(t, `credentials:
+  ops:
+    user: root
+    password_env: KWSSH_TEST_PASS
+plays:
+  - name: uptime
+    hosts: [10.0.0.1, 10.0.0.2]
+    credential: ops
+    commands: [uptime]
+    timeout: 10s
+    batch: 50%
+  - name: disk
+    hosts: [10.0.0.3]
+    credential: ops
+    commands: [df -h]
+`)
+
+	pbs, err := LoadPlayBooks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pbs) != 2 || pbs[0].Name() != "uptime" || pbs[1].Name() != "disk" {
+		t.Fatalf("playbooks = %v", pbs)
+	}
+
+	tasks := pbs[0].m
+	if len(tasks) != 2 || tasks[0].Pass != "secret" || tasks[0].Port != 22 || tasks[0].Timeout.String() != "10s" {
+		t.Errorf("tasks = %+v", tasks)
+	}
+}
+
+func TestLoadPlayBooksErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		// 每一项为 行号: 错误信息, 需要全部出现
+		want []string
+	}{
+		{
+			name: "unknown keys with empty hosts and commands",
+			content: `credentials:
+  ops:
+    user: root
+    password: secret
+    passwd: secret
+plays:
+  - name: uptime
+    credential: ops
+    comands: [uptime]
+`,
+			want: []string{
+				":5: 未知的配置项 passwd",
+				":7: play \"uptime\": hosts 不能为空",
+				":7: play \"uptime\": commands 不能为空",
+				":9: 未知的配置项 comands",
+			},
+		},
+		{
+			name: "undefined credential",
+			content: `plays:
+  - name: uptime
+    hosts: [10.0.0.1]
+    commands: [uptime]
+    credential: ops
+  - name: disk
+    hosts: [10.0.0.1]
+    commands: [df -h]
+`,
+			want: []string{
+				":5: play \"uptime\": 未定义的 credential \"ops\"",
+				":6: play \"disk\": credential 不能为空",
+			},
+		},
+		{
+			name: "bad durations",
+			content: `credentials:
+  ops: {user: root, password: secret}
+plays:
+  - name: uptime
+    hosts: [10.0.0.1]
+    commands: [uptime]
+    credential: ops
+    timeout: 10
+    command_timeout: 5 minutes
+    task_timeout: 1h
+`,
+			want: []string{
+				":8: play \"uptime\": timeout 格式错误 \"10\"",
+				":9: play \"uptime\": command_timeout 格式错误 \"5 minutes\"",
+			},
+		},
+		{
+			name: "credential without auth and missing env",
+			content: `credentials:
+  ops:
+    user: root
+  env:
+    user: root
+    password_env: KWSSH_TEST_UNSET
+plays:
+  - name: uptime
+    hosts: [10.0.0.1]
+    commands: [uptime]
+    credential: ops
+`,
+			want: []string{
+				":2: credential \"ops\": 请指定 password, password_env, key 或 auth",
+				":6: credential \"env\": 环境变量 KWSSH_TEST_UNSET 为空",
+			},
+		},
+		{
+			name:    "empty plays",
+			content: "credentials: {}\nplays: []\n",
+			want:    []string{":2: plays 不能为空"},
+		},
+		{
+			name:    "syntax error",
+			content: "plays:\n  - name: [uptime\n",
+			want:    []string{":1: did not find expected ',' or ']'"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writePlayBook(t, c.content)
+
+			pbs, err := LoadPlayBooks(path)
+			if err == nil {
+				t.Fatalf("LoadPlayBooks = %v, want error", pbs)
+			}
+			if pbs != nil {
+				t.Errorf("playbooks returned with errors: %v", pbs)
+			}
+
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(c.want) {
+				t.Errorf("got %d errors, want %d:\n%s", len(lines), len(c.want), err)
+			}
+			for i, w := range c.want {
+				if i >= len(lines) || !strings.HasPrefix(lines[i], path+w) {
+					t.Errorf("error %d = %q, want prefix %q", i, lines[i%len(lines)], path+w)
+				}
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package kwssh
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"net"
 	"strings"
 	"time"
 
@@ -11,102 +15,337 @@ import (
 
 type SSH struct {
 	client *ssh.Client
+	// 目标主机地址, 经过跳板机时 client.RemoteAddr 不一定是目标IP
+	ip string
+	// 单条命令的超时时间
+	cmdTimeout time.Duration
+	// PlayBook 内共享的跳板机连接, 为空时由 hops 自行管理
+	jumps *jumpPool
+	hops  []*ssh.Client
 }
 
-var (
-	resChan = make(chan commandResult, 100)
-)
-
-type commandResult struct {
-	ip   string
-	user string
-	res  []struct {
-		cmd  string
-		data []byte
-	}
+// CommandResult 单台主机的执行结果
+type CommandResult struct {
+	IP   string
+	User string
+	// 每条命令的执行结果, 按执行顺序排列
+	Res []CommandOutput
+	// 文件传输的结果, 在命令之前执行
+	Copies []CopyOutput
+	// 连接失败, 主机公钥校验失败等主机级别的错误
+	Err error
 }
 
-func (s *SSH) NewClient(target *Task) error {
-	auth := []ssh.AuthMethod{}
-	var timeout time.Duration = 0
+// CommandOutput 单条命令的执行结果
+type CommandOutput struct {
+	Cmd    string
+	Stdout []byte
+	Stderr []byte
+	// 退出码, 被信号终止或连接断开时为 -1
+	ExitCode int
+	// 终止远程进程的信号, 例如 KILL
+	Signal string
+	// 连接断开, 没有收到命令的退出状态
+	ConnLost bool
+	// 命令执行超时, 已向远程进程发送KILL信号
+	TimedOut bool
+	// 其它错误, 例如创建session失败
+	Err error
 
-	if target.SSHType == PUBLICKEY {
-		key, err := os.ReadFile(target.KeyPath)
-		if err != nil {
-			return fmt.Errorf("kwssh: ParsekeyPath err: %#v", err.Error())
-		}
+	Start    time.Time
+	Duration time.Duration
+}
 
-		signer, err := ssh.ParsePrivateKey(key)
-		if err != nil {
-			return fmt.Errorf("kwssh: ParseKey err: %#v", err.Error())
-		}
+// 命令是否执行成功
+func (o CommandOutput) OK() bool {
+	return o.Err == nil && !o.ConnLost && !o.TimedOut && o.Signal == "" && o.ExitCode == 0
+}
 
-		auth = append(auth, ssh.PublicKeys(signer))
+// 失败原因, 成功时为空
+func (o CommandOutput) Status() string {
+	switch {
+	case o.TimedOut:
+		return "timeout"
+	case o.Err != nil:
+		return o.Err.Error()
+	case o.ConnLost:
+		return "connection lost"
+	case o.Signal != "":
+		return "killed by signal " + o.Signal
+	case o.ExitCode != 0:
+		return fmt.Sprintf("exit code %d", o.ExitCode)
 	}
+	return ""
+}
 
-	if target.SSHType == PASSWORD {
-		auth = append(auth, ssh.Password(target.Pass))
+// 主机上的所有文件传输和命令是否都执行成功
+func (r CommandResult) OK() bool {
+	if r.Err != nil {
+		return false
 	}
-
-	if target.Timeout != 0 {
-		timeout = target.Timeout
+	for _, v := range r.Copies {
+		if !v.OK() {
+			return false
+		}
 	}
+	for _, v := range r.Res {
+		if !v.OK() {
+			return false
+		}
+	}
+	return true
+}
 
-	sshConfig := &ssh.ClientConfig{
-		Auth:            auth,
-		User:            target.User,
-		Timeout:         timeout,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// NewClient 连接到目标主机, ctx 取消时中断连接
+// 设置了 Task.ProxyJump 时依次经过跳板机连接
+func (s *SSH) NewClient(ctx context.Context, target *Task) error {
+	sshConfig, agentConn, err := clientConfig(target, target.User)
+	if err != nil {
+		return err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
 	}
 
 	server := fmt.Sprintf("%s:%d", target.IP, target.Port)
-	client, err := ssh.Dial("tcp", server, sshConfig)
+
+	var client *ssh.Client
+	if len(target.ProxyJump) == 0 {
+		client, err = dialContext(ctx, server, sshConfig)
+	} else {
+		via, jumpErr := s.dialJumps(ctx, target)
+		if jumpErr != nil {
+			s.Close()
+			return jumpErr
+		}
+		client, err = dialVia(ctx, via, server, sshConfig)
+		s.dropJump(via, err)
+	}
 	if err != nil {
+		s.Close()
+		// 主机公钥校验失败单独返回, 方便调用方区分
+		var hkErr *HostKeyError
+		if errors.As(err, &hkErr) {
+			return hkErr
+		}
 		return fmt.Errorf("kwssh: connect to [%s] failed, err=%#v", server, err.Error())
 	}
 
 	s.client = client
+	s.ip = target.IP
+	s.cmdTimeout = target.CommandTimeout
 	// fmt.Println("连接到", s.client.RemoteAddr().String(), "成功")
 	return nil
 }
 
-func (s *SSH) RunCommands(cmds []string) (commandResult, error) {
+// 生成连接配置, 跳板机与目标主机使用相同的认证方式和主机公钥校验
+func clientConfig(target *Task, user string) (*ssh.ClientConfig, io.Closer, error) {
+	auth, agentConn, err := authMethods(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hostKey, err := hostKeyCallback(target)
+	if err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		Auth:            auth,
+		User:            user,
+		Timeout:         target.Timeout,
+		HostKeyCallback: hostKey,
+	}, agentConn, nil
+}
+
+// Close 关闭连接, 以及不与其它任务共享的跳板机连接
+func (s *SSH) Close() error {
+	var err error
+	if s.client != nil {
+		err = s.client.Close()
+	}
+	for i := len(s.hops) - 1; i >= 0; i-- {
+		s.hops[i].Close()
+	}
+	s.hops = nil
+	return err
+}
+
+// 与ssh.Dial相同, 但握手也受超时时间和ctx的限制
+func dialContext(ctx context.Context, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	d := net.Dialer{Timeout: config.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return handshake(ctx, conn, addr, config)
+}
+
+// 在已建立的连接上完成ssh握手
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	// 握手期间ctx取消时关闭连接
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if config.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(config.Timeout)); err != nil {
+			// 经过跳板机的连接不支持deadline, 超时后直接关闭
+			t := time.AfterFunc(config.Timeout, func() { conn.Close() })
+			defer t.Stop()
+		}
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// RunCommands 依次执行命令, 执行完成后关闭连接
+// ctx 取消或超时后不再执行剩余的命令
+func (s *SSH) RunCommands(ctx context.Context, cmds []string) (CommandResult, error) {
 
-	r := commandResult{}
 	if len(cmds) == 0 {
-		return r, fmt.Errorf("kw_ssh: commands 不能为0")
+		return CommandResult{}, fmt.Errorf("kw_ssh: commands 不能为0")
 	}
 
-	defer s.client.Close()
+	defer s.Close()
 
-	r.ip = strings.Split(s.client.RemoteAddr().String(), ":")[0]
-	r.user = s.client.User()
+	return s.run(ctx, cmds), nil
+}
 
-	r.res = make([]struct {
-		cmd  string
-		data []byte
-	}, 0)
+// 依次执行命令, 不关闭连接
+func (s *SSH) run(ctx context.Context, cmds []string) CommandResult {
+	r := CommandResult{}
+
+	r.IP = s.ip
+	if r.IP == "" {
+		r.IP = strings.Split(s.client.RemoteAddr().String(), ":")[0]
+	}
+	r.User = s.client.User()
+
+	r.Res = make([]CommandOutput, 0, len(cmds))
 
 	for _, cmd := range cmds {
+		if ctx.Err() != nil {
+			// 剩余的命令没有执行, 整台主机记为失败
+			r.Err = ctx.Err()
+			break
+		}
 
-		session, err := s.client.NewSession()
-		if err != nil {
-			return r, fmt.Errorf("kw_ssh: session create failed, err=%#v", err.Error())
+		cmdCtx, cancel := withTimeout(ctx, s.cmdTimeout)
+		out := runCommand(cmdCtx, s.client, cmd)
+		cancel()
+		r.Res = append(r.Res, out)
+
+		// 连接已断开, 后面的命令不再执行
+		if out.ConnLost {
+			break
 		}
-		defer session.Close()
+	}
 
-		output, _ := session.CombinedOutput(cmd)
+	return r
+}
+
+// 超时时间为0时不设置超时
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+func runCommand(ctx context.Context, client *ssh.Client, cmd string) (out CommandOutput) {
+	out = CommandOutput{Cmd: cmd, Start: time.Now()}
+	defer func() {
+		out.Duration = time.Since(out.Start)
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		out.ExitCode = -1
+		out.Err = fmt.Errorf("kw_ssh: session create failed, err=%#v", err.Error())
+		if errors.Is(err, io.EOF) {
+			out.ConnLost = true
+		}
+		return out
+	}
+	defer session.Close()
 
-		r.res = append(r.res, struct {
-			cmd  string
-			data []byte
-		}{
-			cmd:  cmd,
-			data: output,
-		})
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
 
+	if err := session.Start(cmd); err != nil {
+		out.ExitCode = -1
+		out.Err = fmt.Errorf("kw_ssh: start command failed, err=%#v", err.Error())
+		return out
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// 超时或取消: 通知远程进程退出并关闭session
+		session.Signal(ssh.SIGKILL)
+		session.Close()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			// 对端无响应, 关闭连接让Wait返回
+			client.Close()
+			<-done
+		}
+
+		out.Stdout = stdout.Bytes()
+		out.Stderr = stderr.Bytes()
+		out.ExitCode = -1
+		out.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+		out.Err = ctx.Err()
+		return out
+	}
+
+	out.Stdout = stdout.Bytes()
+	out.Stderr = stderr.Bytes()
+
+	var exitErr *ssh.ExitError
+	var missErr *ssh.ExitMissingError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		out.ExitCode = exitErr.ExitStatus()
+		out.Signal = exitErr.Signal()
+		if out.Signal != "" {
+			out.ExitCode = -1
+		}
+	case errors.As(err, &missErr), errors.Is(err, io.EOF):
+		out.ExitCode = -1
+		out.ConnLost = true
+	default:
+		out.ExitCode = -1
+		out.Err = err
 	}
 
-	resChan <- r
-	return r, nil
+	return out
 }
@@ -0,0 +1,250 @@
+package kwssh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"zeus/kwssh/sshtest"
+)
+
+func newServer(t *testing.T, cfg sshtest.Config) *sshtest.Server {
+	t.Helper()
+
+	if cfg.Users == nil {
+		cfg.Users = map[string]sshtest.User{"root": {Password: "secret"}}
+	}
+	s, err := sshtest.NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testTask(s *sshtest.Server, cmds ...string) Task {
+	return Task{
+		IP:            s.Host(),
+		Port:          s.Port(),
+		SSHType:       PASSWORD,
+		User:          "root",
+		Pass:          "secret",
+		Command:       cmds,
+		Timeout:       5 * time.Second,
+		HostKeyPolicy: HOSTKEY_INSECURE,
+	}
+}
+
+func dial(t *testing.T, task Task) *SSH {
+	t.Helper()
+
+	cli := &SSH{}
+	if err := cli.NewClient(context.Background(), &task); err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func TestNewClientPassword(t *testing.T) {
+	s := newServer(t, sshtest.Config{})
+
+	task := testTask(s)
+	dial(t, task).client.Close()
+
+	task.Pass = "wrong"
+	if err := (&SSH{}).NewClient(context.Background(), &task); err == nil {
+		t.Fatal("expected authentication failure")
+	}
+}
+
+func TestNewClientPublicKey(t *testing.T) {
+	keyPath, _, pub := writeKey(t, "")
+	s := newServer(t, sshtest.Config{
+		Users: map[string]sshtest.User{"deploy": {Keys: []ssh.PublicKey{pub}}},
+	})
+
+	task := testTask(s)
+	task.User = "deploy"
+	task.SSHType = PUBLICKEY
+	task.KeyPath = keyPath
+	dial(t, task).client.Close()
+}
+
+func TestNewClientHostKey(t *testing.T) {
+	s := newServer(t, sshtest.Config{})
+	other := newServer(t, sshtest.Config{})
+	dir := t.TempDir()
+
+	// 严格模式: known_hosts 中的公钥一致
+	good := filepath.Join(dir, "good")
+	if err := s.WriteKnownHosts(good); err != nil {
+		t.Fatal(err)
+	}
+	task := testTask(s)
+	task.HostKeyPolicy = HOSTKEY_STRICT
+	task.KnownHosts = good
+	dial(t, task).client.Close()
+
+	// 严格模式: 公钥不一致
+	bad := filepath.Join(dir, "bad")
+	writeKnownHost(t, bad, s.Addr(), other.HostKey())
+
+	task.KnownHosts = bad
+	err := (&SSH{}).NewClient(context.Background(), &task)
+	var hkErr *HostKeyError
+	if !errors.As(err, &hkErr) {
+		t.Fatalf("expected HostKeyError, got %v", err)
+	}
+
+	// 首次信任: 第一次写入, 第二次校验通过
+	tofu := filepath.Join(dir, "sub", "tofu")
+	task.HostKeyPolicy = HOSTKEY_TOFU
+	task.KnownHosts = tofu
+	dial(t, task).client.Close()
+	dial(t, task).client.Close()
+
+	data, err := os.ReadFile(tofu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Fatalf("known_hosts has %d lines, want 1", n)
+	}
+
+	// 首次信任: 已记录的主机公钥变化
+	task.IP, task.Port = other.Host(), other.Port()
+	writeKnownHost(t, tofu, other.Addr(), s.HostKey())
+	if err := (&SSH{}).NewClient(context.Background(), &task); !errors.As(err, &hkErr) {
+		t.Fatalf("expected HostKeyError, got %v", err)
+	}
+}
+
+func writeKnownHost(t *testing.T, path, addr string, key ssh.PublicKey) {
+	t.Helper()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err := os.WriteFile(path, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"ok":     {Stdout: "out\n", Stderr: "warn\n"},
+			"fail":   {Stderr: "boom\n", ExitCode: 3},
+			"killed": {Signal: "KILL"},
+			"slow":   {Stdout: "done", Delay: 50 * time.Millisecond},
+		},
+	})
+
+	res, err := dial(t, testTask(s)).RunCommands(context.Background(), []string{"ok", "fail", "killed", "slow", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.IP != s.Host() || res.User != "root" {
+		t.Fatalf("unexpected host %s@%s", res.User, res.IP)
+	}
+	if len(res.Res) != 5 {
+		t.Fatalf("got %d results, want 5", len(res.Res))
+	}
+
+	ok, fail, killed, slow, missing := res.Res[0], res.Res[1], res.Res[2], res.Res[3], res.Res[4]
+
+	if !ok.OK() || string(ok.Stdout) != "out\n" || string(ok.Stderr) != "warn\n" {
+		t.Errorf("ok: %+v", ok)
+	}
+	if fail.OK() || fail.ExitCode != 3 || string(fail.Stderr) != "boom\n" {
+		t.Errorf("fail: %+v", fail)
+	}
+	if killed.OK() || killed.Signal != "KILL" || killed.ExitCode != -1 {
+		t.Errorf("killed: %+v", killed)
+	}
+	if !slow.OK() || slow.Duration < 50*time.Millisecond || slow.Start.IsZero() {
+		t.Errorf("slow: %+v", slow)
+	}
+	if missing.ExitCode != 127 {
+		t.Errorf("missing: %+v", missing)
+	}
+	if res.OK() {
+		t.Error("result with failed commands reported OK")
+	}
+}
+
+func TestRunCommandsDisconnect(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"reboot": {Stdout: "bye", Disconnect: true},
+		},
+	})
+
+	res, err := dial(t, testTask(s)).RunCommands(context.Background(), []string{"reboot", "uptime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Res) != 1 {
+		t.Fatalf("commands after disconnect should not run, got %d results", len(res.Res))
+	}
+	if !res.Res[0].ConnLost || res.Res[0].Status() != "connection lost" {
+		t.Fatalf("reboot: %+v", res.Res[0])
+	}
+}
+
+func TestRunCommandsTimeout(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"omreport": {Stdout: "never", Delay: 10 * time.Second},
+			"uptime":   {Stdout: "up"},
+		},
+	})
+
+	task := testTask(s)
+	task.CommandTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	res, err := dial(t, task).RunCommands(context.Background(), []string{"omreport", "uptime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("hung command was not interrupted")
+	}
+	if len(res.Res) != 2 {
+		t.Fatalf("got %d results, want 2", len(res.Res))
+	}
+	if hung := res.Res[0]; !hung.TimedOut || hung.OK() || hung.Status() != "timeout" {
+		t.Errorf("omreport: %+v", hung)
+	}
+	if up := res.Res[1]; !up.OK() || string(up.Stdout) != "up" {
+		t.Errorf("command after timeout: %+v", up)
+	}
+}
+
+func TestRunCommandsCancel(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{
+			"sleep": {Delay: 10 * time.Second},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	res, err := dial(t, testTask(s)).RunCommands(ctx, []string{"sleep", "uptime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(res.Err, context.Canceled) || len(res.Res) != 1 {
+		t.Fatalf("unexpected result after cancel: %+v", res)
+	}
+	if res.Res[0].TimedOut || !errors.Is(res.Res[0].Err, context.Canceled) {
+		t.Fatalf("sleep: %+v", res.Res[0])
+	}
+}
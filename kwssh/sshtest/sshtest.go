@@ -0,0 +1,434 @@
+// Package sshtest 提供在本机启动的ssh服务, 用于kwssh的集成测试
+package sshtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// User 允许登录的用户, 密码和公钥任选其一
+type User struct {
+	Password string
+	Keys     []ssh.PublicKey
+	// 密码只能通过 keyboard-interactive 认证
+	KeyboardInteractive bool
+}
+
+// Response 命令的预设响应
+type Response struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	// 不为空时以信号结束, 例如 KILL, 忽略ExitCode
+	Signal string
+	// 输出之前等待的时间
+	Delay time.Duration
+	// 输出之后直接断开连接, 不返回退出状态
+	Disconnect bool
+}
+
+// Config 测试服务配置
+type Config struct {
+	Users map[string]User
+	// 命令 -> 响应
+	Commands map[string]Response
+	// 未配置的命令的响应, 为空时返回 exit 127
+	Default *Response
+	// 每个连接在握手前等待的时间
+	Latency time.Duration
+	// 支持sftp子系统, 直接读写本机文件
+	SFTP bool
+}
+
+// Server 在 127.0.0.1 随机端口上监听的ssh服务
+type Server struct {
+	ln      net.Listener
+	config  *ssh.ServerConfig
+	hostKey ssh.PublicKey
+	latency time.Duration
+	sftp    bool
+
+	mu       sync.Mutex
+	commands map[string]Response
+	def      *Response
+	executed []string
+	conns    map[*ssh.ServerConn]struct{}
+	logins   int
+
+	wg sync.WaitGroup
+}
+
+// NewServer 启动测试服务, 使用结束后调用Close
+func NewServer(cfg Config) (*Server, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		hostKey:  signer.PublicKey(),
+		latency:  cfg.Latency,
+		sftp:     cfg.SFTP,
+		commands: make(map[string]Response),
+		def:      cfg.Default,
+		conns:    make(map[*ssh.ServerConn]struct{}),
+	}
+	for k, v := range cfg.Commands {
+		s.commands[k] = v
+	}
+
+	users := cfg.Users
+	s.config = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			u, ok := users[c.User()]
+			if ok && !u.KeyboardInteractive && u.Password != "" && u.Password == string(pass) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("sshtest: password rejected for %q", c.User())
+		},
+		KeyboardInteractiveCallback: func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			u, ok := users[c.User()]
+			if !ok || u.Password == "" {
+				return nil, fmt.Errorf("sshtest: keyboard-interactive rejected for %q", c.User())
+			}
+			answers, err := client(c.User(), "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) == 1 && answers[0] == u.Password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("sshtest: keyboard-interactive rejected for %q", c.User())
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			for _, k := range users[c.User()].Keys {
+				if bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf("sshtest: public key rejected for %q", c.User())
+		},
+	}
+	s.config.AddHostKey(signer)
+
+	s.ln, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s, nil
+}
+
+// Addr 监听地址 host:port
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Host 监听的IP
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.Addr())
+	return host
+}
+
+// Port 监听的端口
+func (s *Server) Port() int32 {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	p, _ := strconv.Atoi(port)
+	return int32(p)
+}
+
+// HostKey 服务端公钥
+func (s *Server) HostKey() ssh.PublicKey {
+	return s.hostKey
+}
+
+// WriteKnownHosts 把服务端公钥写入known_hosts文件
+func (s *Server) WriteKnownHosts(path string) error {
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.Addr())}, s.hostKey)
+	return os.WriteFile(path, []byte(line+"\n"), 0600)
+}
+
+// SetResponse 设置或修改命令的响应
+func (s *Server) SetResponse(cmd string, r Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[cmd] = r
+}
+
+// Executed 已执行过的命令, 按收到的顺序排列
+func (s *Server) Executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.executed...)
+}
+
+// Logins 登录成功的连接数, 包括已断开的连接
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+	}
+
+	sc, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	s.mu.Lock()
+	s.conns[sc] = struct{}{}
+	s.logins++
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, sc)
+		s.mu.Unlock()
+		sc.Close()
+	}()
+
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for nc := range chans {
+		if nc.ChannelType() == "direct-tcpip" {
+			// 作为跳板机转发连接
+			wg.Add(1)
+			go func(nc ssh.NewChannel) {
+				defer wg.Done()
+				forward(nc)
+			}(nc)
+			continue
+		}
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, in, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleSession(sc, ch, in)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Server) handleSession(sc *ssh.ServerConn, ch ssh.Channel, in <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range in {
+		if req.Type == "subsystem" && s.sftp {
+			var payload struct{ Name string }
+			if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go ssh.DiscardRequests(in)
+			serveSFTP(ch)
+			return
+		}
+		if req.Type != "exec" {
+			// 信号等其它请求直接忽略
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			continue
+		}
+
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		// 之后的请求由interrupted读取
+		sigs := interrupted(in)
+
+		r := s.response(payload.Command)
+		if r.Delay > 0 {
+			select {
+			case <-time.After(r.Delay):
+			case sig, ok := <-sigs:
+				if ok {
+					// 收到客户端发送的信号, 模拟进程被信号终止
+					sendExitSignal(ch, sig)
+				}
+				return
+			}
+		}
+
+		ch.Write([]byte(r.Stdout))
+		ch.Stderr().Write([]byte(r.Stderr))
+
+		if r.Disconnect {
+			sc.Close()
+			return
+		}
+
+		if r.Signal != "" {
+			sendExitSignal(ch, r.Signal)
+			return
+		}
+
+		status := make([]byte, 4)
+		binary.BigEndian.PutUint32(status, uint32(r.ExitCode))
+		ch.SendRequest("exit-status", false, status)
+		return
+	}
+}
+
+// 在session上提供sftp服务, 客户端关闭后返回
+func serveSFTP(ch ssh.Channel) {
+	server, err := sftp.NewServer(ch)
+	if err != nil {
+		return
+	}
+	server.Serve()
+	server.Close()
+}
+
+// 处理 direct-tcpip 请求, 连接目标地址并双向转发
+func forward(nc ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		nc.Reject(ssh.ConnectionFailed, "invalid payload")
+		return
+	}
+
+	addr := net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	go ssh.DiscardRequests(reqs)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(conn, ch)
+		// 客户端关闭channel后断开目标连接
+		conn.Close()
+		close(done)
+	}()
+	io.Copy(ch, conn)
+	ch.Close()
+	<-done
+}
+
+func (s *Server) response(cmd string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.executed = append(s.executed, cmd)
+
+	if r, ok := s.commands[cmd]; ok {
+		return r
+	}
+	if s.def != nil {
+		return *s.def
+	}
+	return Response{Stderr: "sh: " + cmd + ": command not found\n", ExitCode: 127}
+}
+
+func sendExitSignal(ch ssh.Channel, sig string) {
+	ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+		Signal     string
+		CoreDumped bool
+		Error      string
+		Lang       string
+	}{Signal: sig}))
+}
+
+// 读取session上的其余请求, 收到信号时返回信号名称; 客户端关闭session时channel关闭
+func interrupted(in <-chan *ssh.Request) <-chan string {
+	sigs := make(chan string, 1)
+	go func() {
+		defer close(sigs)
+		for req := range in {
+			if req.Type == "signal" {
+				var payload struct{ Signal string }
+				ssh.Unmarshal(req.Payload, &payload)
+				select {
+				case sigs <- payload.Signal:
+				default:
+				}
+			}
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+	return sigs
+}
@@ -0,0 +1,83 @@
+package kwssh
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrAborted 前面批次失败的主机过多, 剩余的主机没有执行
+var ErrAborted = errors.New("kwssh: 前面批次失败的主机过多, 未执行")
+
+// Strategy 分批执行策略, 零值表示所有主机同时执行(受并发数量限制)
+// 每批全部结束后才开始下一批
+type Strategy struct {
+	// 每批的主机数量
+	BatchSize int
+	// 每批的主机数量占总数的百分比, BatchSize 为0时使用
+	BatchPercent float64
+	// 先单独在第一台主机上执行, 作为第一批
+	Canary bool
+	// 累计失败的主机数量超过该值时不再执行后续批次, 负数表示不中止
+	// 零值0表示任意一台主机失败后即中止, 例如 Strategy{BatchSize: 10} 第一批有失败时不执行第二批
+	MaxFailures int
+}
+
+// ParseBatch 解析每批的主机数量, 例如 10 或 20%
+func ParseBatch(s string) (size int, percent float64, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+
+	if v, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err = strconv.ParseFloat(v, 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, 0, fmt.Errorf("kwssh: 批次百分比错误 %q", s)
+		}
+		return 0, percent, nil
+	}
+
+	size, err = strconv.Atoi(s)
+	if err != nil || size <= 0 {
+		return 0, 0, fmt.Errorf("kwssh: 批次主机数量错误 %q", s)
+	}
+	return size, 0, nil
+}
+
+// 把n台主机分为多批, 返回每批的主机数量
+func (s Strategy) batches(n int) []int {
+	if n == 0 {
+		return nil
+	}
+
+	sizes := make([]int, 0)
+	if s.Canary {
+		sizes = append(sizes, 1)
+		n--
+	}
+
+	size := s.BatchSize
+	if size <= 0 && s.BatchPercent > 0 {
+		size = int(math.Ceil(float64(n) * s.BatchPercent / 100))
+	}
+	if size <= 0 {
+		size = n
+	}
+
+	for n > 0 {
+		if size > n {
+			size = n
+		}
+		sizes = append(sizes, size)
+		n -= size
+	}
+	return sizes
+}
+
+// 失败的主机数量是否超过限制
+func (s Strategy) exceeded(failed int) bool {
+	return s.MaxFailures >= 0 && failed > s.MaxFailures
+}
@@ -0,0 +1,96 @@
+package kwssh
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"zeus/kwssh/sshtest"
+)
+
+func TestParseBatch(t *testing.T) {
+	if size, pct, err := ParseBatch("10"); err != nil || size != 10 || pct != 0 {
+		t.Fatalf("ParseBatch(10) = %d, %v, %v", size, pct, err)
+	}
+	if size, pct, err := ParseBatch("25%"); err != nil || size != 0 || pct != 25 {
+		t.Fatalf("ParseBatch(25%%) = %d, %v, %v", size, pct, err)
+	}
+	for _, bad := range []string{"0", "-1", "x", "0%", "120%"} {
+		if _, _, err := ParseBatch(bad); err == nil {
+			t.Errorf("ParseBatch(%q) should fail", bad)
+		}
+	}
+}
+
+func TestStrategyBatches(t *testing.T) {
+	cases := []struct {
+		s    Strategy
+		n    int
+		want []int
+	}{
+		{Strategy{}, 5, []int{5}},
+		{Strategy{BatchSize: 2}, 5, []int{2, 2, 1}},
+		{Strategy{BatchPercent: 30}, 10, []int{3, 3, 3, 1}},
+		{Strategy{Canary: true, BatchPercent: 50}, 5, []int{1, 2, 2}},
+		{Strategy{Canary: true}, 4, []int{1, 3}},
+		{Strategy{Canary: true}, 1, []int{1}},
+		{Strategy{BatchSize: 3}, 0, nil},
+	}
+	for _, c := range cases {
+		if got := c.s.batches(c.n); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%+v.batches(%d) = %v, want %v", c.s, c.n, got, c.want)
+		}
+	}
+}
+
+func TestPlayBookCanaryAbort(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{"sysctl -w vm.swappiness=1": {ExitCode: 1}},
+	})
+
+	pb := newTestPlayBook(t, s, "canary", 5, "sysctl -w vm.swappiness=1")
+	pb.SetStrategy(Strategy{Canary: true, BatchSize: 2})
+
+	var failed, aborted int
+	for res := range pb.Exec(context.Background()) {
+		switch {
+		case errors.Is(res.Err, ErrAborted):
+			aborted++
+		case !res.OK():
+			failed++
+		}
+	}
+	if failed != 1 || aborted != 4 {
+		t.Fatalf("failed = %d, aborted = %d", failed, aborted)
+	}
+	if n := len(s.Executed()); n != 1 {
+		t.Fatalf("command executed on %d hosts, want 1", n)
+	}
+
+	// 允许失败时执行所有批次
+	pb.SetStrategy(Strategy{Canary: true, BatchSize: 2, MaxFailures: -1})
+	for res := range pb.Exec(context.Background()) {
+		if errors.Is(res.Err, ErrAborted) {
+			t.Fatalf("host %s aborted with MaxFailures -1", res.IP)
+		}
+	}
+}
+
+func TestPlayBookBatchesSerial(t *testing.T) {
+	s := newServer(t, sshtest.Config{
+		Commands: map[string]sshtest.Response{"sleep": {Stdout: "ok\n", Delay: 50 * time.Millisecond}},
+	})
+
+	pb := newTestPlayBook(t, s, "batches", 6, "sleep")
+	pb.SetParallel(10)
+	pb.SetStrategy(Strategy{BatchSize: 2})
+
+	start := time.Now()
+	collect(t, pb, "ok\n")
+	// 3批依次执行
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("3 batches took %v", d)
+	}
+}
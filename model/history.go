@@ -0,0 +1,120 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 机器信息的历史快照, 每次采集结果与数据库中的信息不同时保存旧的信息
+type Machine_History_MODEL struct {
+	ID          int64        `db:"id"`
+	SN          string       `db:"sn"`
+	CollectedAt sql.NullTime `db:"collected_at"`
+	ReplacedAt  time.Time    `db:"replaced_at"`
+	// Machine_INFO 的json
+	Snapshot string `db:"snapshot"`
+}
+
+// 解析快照中的机器信息
+func (h Machine_History_MODEL) Info() (Machine_INFO, error) {
+	var info Machine_INFO
+	err := json.Unmarshal([]byte(h.Snapshot), &info)
+	return info, err
+}
+
+// 查询一台机器的历史快照, 按替换时间从新到旧
+func (s *sqlStore) QueryHistory(sn string) ([]Machine_History_MODEL, error) {
+
+	history := make([]Machine_History_MODEL, 0)
+
+	err := s.db.Select(&history, "SELECT id, sn, collected_at, replaced_at, snapshot FROM machine_info_history WHERE sn = ? ORDER BY replaced_at DESC, id DESC", sn)
+	if err != nil {
+		fmt.Printf("query history err, err=%#v", err)
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// 数据库中已有的信息与本次采集的不同时, 写入 machine_info_history
+func (s *sqlStore) saveHistory(tx *sqlx.Tx, info Machine_INFO, now time.Time) error {
+	old, collectedAt, found, err := readMachine(tx, info.Base.SN, s.dialect.lockRow)
+	if err != nil || !found || sameMachine(old, info) {
+		return err
+	}
+
+	snapshot, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO machine_info_history (sn, collected_at, replaced_at, snapshot) VALUES (?, ?, ?, ?)",
+		info.Base.SN, collectedAt, now, string(snapshot))
+	return err
+}
+
+// 查询数据库中一台机器的信息, 没有该sn时found为false
+func (s *sqlStore) QueryMachine(sn string) (info Machine_INFO, found bool, err error) {
+	info, _, found, err = readMachine(s.db, sn, "")
+	if err != nil {
+		fmt.Printf("query machine err, err=%#v", err)
+	}
+	return info, found, err
+}
+
+// 读取数据库中一台机器的信息, lock 为 dialect.lockRow 时在事务中锁定该行
+func readMachine(q sqlx.Queryer, sn string, lock string) (info Machine_INFO, collectedAt sql.NullTime, found bool, err error) {
+	base := struct {
+		Machine_Base_INFO_MODEL
+		CollectedAt sql.NullTime `db:"collected_at"`
+	}{}
+
+	err = sqlx.Get(q, &base, `SELECT sn, IFNULL(ip, '') AS ip, IFNULL(model, '') AS model, IFNULL(operating_system, '') AS operating_system,
+		IFNULL(kernel_version, '') AS kernel_version, IFNULL(cpu, '') AS cpu, IFNULL(memory, '') AS memory, IFNULL(power, '') AS power,
+		collected_at FROM machine_base_info WHERE sn = ? `+lock, sn)
+	if err == sql.ErrNoRows {
+		return info, collectedAt, false, nil
+	}
+	if err != nil {
+		return info, collectedAt, false, err
+	}
+	info.Base, collectedAt = base.Machine_Base_INFO_MODEL, base.CollectedAt
+
+	err = sqlx.Select(q, &info.Memorys, "SELECT sn, IFNULL(location, '') AS location, IFNULL(type, '') AS type, IFNULL(size, '') AS size FROM machine_memory_info WHERE sn = ?", sn)
+	if err != nil {
+		return info, collectedAt, false, err
+	}
+	err = sqlx.Select(q, &info.Disks, "SELECT sn, IFNULL(disk, '') AS disk, IFNULL(capacity, '') AS capacity, IFNULL(media, '') AS media, IFNULL(serial, '') AS serial FROM machine_disk_info WHERE sn = ?", sn)
+	if err != nil {
+		return info, collectedAt, false, err
+	}
+	err = sqlx.Select(q, &info.Raids, "SELECT sn, IFNULL(raid_level, '') AS raid_level, IFNULL(capacity, '') AS capacity FROM machine_raid_info WHERE sn = ?", sn)
+	if err != nil {
+		return info, collectedAt, false, err
+	}
+
+	return info, collectedAt, true, nil
+}
+
+// 比较两次采集的信息, 内存, 磁盘和RAID不区分顺序
+func sameMachine(a, b Machine_INFO) bool {
+	return a.Base == b.Base &&
+		reflect.DeepEqual(sortedRows(a.Memorys), sortedRows(b.Memorys)) &&
+		reflect.DeepEqual(sortedRows(a.Disks), sortedRows(b.Disks)) &&
+		reflect.DeepEqual(sortedRows(a.Raids), sortedRows(b.Raids))
+}
+
+func sortedRows[T any](rows []T) []string {
+	out := make([]string, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, fmt.Sprintf("%#v", r))
+	}
+	sort.Strings(out)
+	return out
+}
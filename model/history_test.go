@@ -0,0 +1,40 @@
+package model
+
+import "testing"
+
+func TestSameMachine(t *testing.T) {
+	a := Machine_INFO{
+		Base: Machine_Base_INFO_MODEL{SN: "7XK2N33", KernelVersion: "3.10.0"},
+		Memorys: []Machine_Memory_INFO_MODEL{
+			{SN: "7XK2N33", Location: "A1", Size: "16384 MB"},
+			{SN: "7XK2N33", Location: "A2", Size: "16384 MB"},
+		},
+		Disks: []Machine_Disk_INFO_MODEL{{SN: "7XK2N33", Product: "ST600MM0088"}},
+	}
+
+	// 顺序不同, 空列表与nil相同
+	b := a
+	b.Memorys = []Machine_Memory_INFO_MODEL{a.Memorys[1], a.Memorys[0]}
+	b.Raids = []Machine_RAID_INFO_MODEL{}
+	if !sameMachine(a, b) {
+		t.Error("reordered rows should be the same machine")
+	}
+
+	c := a
+	c.Base.KernelVersion = "4.18.0"
+	if sameMachine(a, c) {
+		t.Error("kernel upgrade not detected")
+	}
+
+	d := a
+	d.Disks = []Machine_Disk_INFO_MODEL{{SN: "7XK2N33", Product: "ST600MM0099"}}
+	if sameMachine(a, d) {
+		t.Error("disk replacement not detected")
+	}
+
+	e := a
+	e.Memorys = a.Memorys[:1]
+	if sameMachine(a, e) {
+		t.Error("removed DIMM not detected")
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+import "fmt"
+
+// 主机清单使用的字段, 资产信息来自 idc_machine_info
+type Machine_Host_MODEL struct {
+	SN          string `db:"sn"`
+	IP          string `db:"ip"`
+	Model       string `db:"model"`
+	Label       string `db:"label"`
+	ServiceName string `db:"service_name"`
+	Cabinet     string `db:"cabinet"`
+}
+
+// 查询已采集的主机及其资产信息, 没有资产信息的主机相应字段为空
+// idc_machine_info 由升级脚本 0004 创建
+func (s *sqlStore) QueryHosts() ([]Machine_Host_MODEL, error) {
+
+	hosts := make([]Machine_Host_MODEL, 0)
+
+	err := s.db.Select(&hosts, `SELECT b.sn, IFNULL(b.ip, '') AS ip, IFNULL(b.model, '') AS model,
+		IFNULL(i.label, '') AS label, IFNULL(i.service_name, '') AS service_name, IFNULL(i.cabinet, '') AS cabinet
+		FROM machine_base_info b LEFT JOIN idc_machine_info i ON b.sn = i.sn
+		ORDER BY b.ip`)
+	if err != nil {
+		fmt.Printf("query hosts err, err=%#v", err)
+		return nil, err
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,271 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 数据库表结构的版本化升级脚本, 每种数据库一个目录, 版本和表结构相同
+// 文件名为 <版本>_<名称>.up.sql 和 <版本>_<名称>.down.sql
+//
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration 一个版本的表结构变更
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 升级脚本及其执行状态
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// 按版本排序的 dir 中的所有升级脚本
+func loadMigrations(dir string) ([]Migration, error) {
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		m := migrationName.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("model: 升级脚本文件名错误 [%s]", e.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mg
+		}
+		if mg.Name != m[2] {
+			return nil, fmt.Errorf("model: 版本 %d 有多个升级脚本 [%s] [%s]", version, mg.Name, m[2])
+		}
+		if m[3] == "up" {
+			mg.Up = string(data)
+		} else {
+			mg.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("model: 版本 %d [%s] 缺少 up 或 down 脚本", mg.Version, mg.Name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatus 所有升级脚本的执行状态, 只读取, 没有 schema_version 表时都是未执行
+func (s *sqlStore) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(s.dialect.migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := s.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{Migration: m, Applied: ok, AppliedAt: at})
+	}
+	return status, nil
+}
+
+// Migrate 按版本顺序执行所有未执行的升级脚本, 返回本次执行的脚本
+// 某个脚本失败时停止, 之前的脚本已经生效
+func (s *sqlStore) Migrate() ([]Migration, error) {
+	if err := s.createSchemaVersion(); err != nil {
+		return nil, err
+	}
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range status {
+		if m.Applied {
+			continue
+		}
+		err := s.execMigration(m.Version, m.Name, m.Up, true)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m.Migration)
+	}
+	return done, nil
+}
+
+// Rollback 从最新的版本开始回滚 steps 个已执行的升级脚本, 返回回滚的脚本
+func (s *sqlStore) Rollback(steps int) ([]Migration, error) {
+	if err := s.createSchemaVersion(); err != nil {
+		return nil, err
+	}
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(status) - 1; i >= 0 && len(done) < steps; i-- {
+		m := status[i]
+		if !m.Applied {
+			continue
+		}
+		err := s.execMigration(m.Version, m.Name, m.Down, false)
+		if err != nil {
+			return done, err
+		}
+		done = append(done, m.Migration)
+	}
+	return done, nil
+}
+
+// CheckSchema 还有未执行的升级脚本时返回错误
+func (s *sqlStore) CheckSchema() error {
+	status, err := s.MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range status {
+		if !m.Applied {
+			return fmt.Errorf("model: 数据库表结构不是最新版本, 缺少 %04d_%s, 请先执行 prun db migrate", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// 只在 Migrate 和 Rollback 中创建 schema_version, 查询状态只需要只读权限
+func (s *sqlStore) createSchemaVersion() error {
+	_, err := s.db.Exec(s.dialect.schemaVersion)
+	if err != nil {
+		return fmt.Errorf("model: create table schema_version err, err=%w", err)
+	}
+	return nil
+}
+
+// 已执行的版本, 没有 schema_version 表时为空
+func (s *sqlStore) appliedVersions() (map[int]time.Time, error) {
+	var n int
+	err := s.db.Get(&n, s.dialect.tableExists, "schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("model: query schema_version err, err=%w", err)
+	}
+	if n == 0 {
+		return map[int]time.Time{}, nil
+	}
+
+	rows := []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}{}
+	err = s.db.Select(&rows, "SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("model: query schema_version err, err=%w", err)
+	}
+
+	applied := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// 在同一个连接上依次执行脚本中的语句, 并更新 schema_version
+// SQLite 在一个事务中执行, 失败时整体回滚;
+// MySQL 的DDL会隐式提交, 不使用事务, 脚本中的语句需要能够重复执行
+func (s *sqlStore) execMigration(version int, name, script string, up bool) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var exec interface {
+		ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	} = conn
+	var tx *sql.Tx
+	if s.dialect.txDDL {
+		tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := exec.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("model: %04d_%s 执行失败, err=%w", version, name, err)
+		}
+	}
+
+	if up {
+		_, err = exec.ExecContext(ctx, "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", version, name, time.Now())
+	} else {
+		_, err = exec.ExecContext(ctx, "DELETE FROM schema_version WHERE version = ?", version)
+	}
+	if err != nil {
+		return fmt.Errorf("model: update schema_version err, err=%w", err)
+	}
+
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+// 去掉 -- 开头的注释行, 按行尾的分号拆分语句
+func splitStatements(script string) []string {
+	var stmts []string
+	var cur strings.Builder
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"))
+			cur.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(cur.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
@@ -0,0 +1,62 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for _, d := range []*dialect{mysqlDialect, sqliteDialect} {
+		migrations, err := loadMigrations(d.migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 每种数据库的版本相同
+		var names []string
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s: migration %s has version %d, want %d", d.name, m.Name, m.Version, i+1)
+			}
+			names = append(names, m.Name)
+		}
+		want := []string{"machine_info", "machine_history", "ping_event", "idc_machine_info", "disk_serial"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: migrations = %v, want %v", d.name, names, want)
+		}
+
+		// 所有表都由升级脚本创建
+		var up strings.Builder
+		for _, m := range migrations {
+			up.WriteString(m.Up)
+		}
+		for _, table := range []string{"machine_base_info", "machine_disk_info", "machine_memory_info", "machine_raid_info",
+			"machine_info_history", "machine_ping_event", "idc_machine_info"} {
+			if !strings.Contains(up.String(), "CREATE TABLE IF NOT EXISTS "+table+" (") {
+				t.Errorf("%s: table %s is not created by any migration", d.name, table)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment;
+CREATE TABLE a (
+    id INT
+);
+
+SET @ddl = IF(1 = 0,
+    'SELECT 1',
+    'SELECT 2');
+DROP TABLE b`
+
+	want := []string{
+		"CREATE TABLE a (\n    id INT\n)",
+		"SET @ddl = IF(1 = 0,\n    'SELECT 1',\n    'SELECT 2')",
+		"DROP TABLE b",
+	}
+	if got := splitStatements(script); !reflect.DeepEqual(got, want) {
+		t.Errorf("splitStatements = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS machine_info_history;
+ALTER TABLE machine_base_info DROP COLUMN collected_at;
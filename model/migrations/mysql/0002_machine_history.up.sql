@@ -0,0 +1,18 @@
+-- 采集时间, 之前的版本在 fetchToDB 时可能已经增加了该字段
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.COLUMNS
+    WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'machine_base_info' AND COLUMN_NAME = 'collected_at') = 0,
+    'ALTER TABLE machine_base_info ADD COLUMN collected_at DATETIME',
+    'SELECT 1');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- 重复采集时被替换的旧信息, snapshot 为 Machine_INFO 的json
+CREATE TABLE IF NOT EXISTS machine_info_history (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    sn VARCHAR(255) NOT NULL,
+    collected_at DATETIME,
+    replaced_at DATETIME NOT NULL,
+    snapshot TEXT NOT NULL,
+    KEY idx_sn (sn, replaced_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
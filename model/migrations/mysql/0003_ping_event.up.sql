@@ -0,0 +1,11 @@
+-- pping 监控模式下的主机状态变化
+CREATE TABLE IF NOT EXISTS machine_ping_event (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    ip VARCHAR(64) NOT NULL,
+    from_state VARCHAR(16),
+    to_state VARCHAR(16),
+    loss DOUBLE,
+    avg_ms DOUBLE,
+    changed_at DATETIME,
+    KEY idx_ip (ip, changed_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
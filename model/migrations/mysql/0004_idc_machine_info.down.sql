@@ -0,0 +1 @@
+DROP TABLE IF EXISTS idc_machine_info;
@@ -0,0 +1 @@
+ALTER TABLE machine_disk_info DROP COLUMN serial;
@@ -0,0 +1,8 @@
+-- 硬盘序列号, 同型号硬盘更换后只有序列号不同
+SET @ddl = IF((SELECT COUNT(*) FROM information_schema.COLUMNS
+    WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'machine_disk_info' AND COLUMN_NAME = 'serial') = 0,
+    'ALTER TABLE machine_disk_info ADD COLUMN serial VARCHAR(255)',
+    'SELECT 1');
+PREPARE stmt FROM @ddl;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS machine_raid_info;
+DROP TABLE IF EXISTS machine_memory_info;
+DROP TABLE IF EXISTS machine_disk_info;
+DROP TABLE IF EXISTS machine_base_info;
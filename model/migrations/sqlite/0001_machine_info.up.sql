@@ -0,0 +1,34 @@
+-- 机器基本信息, 内存, 磁盘和RAID, 与 mysql 目录中的表结构相同
+CREATE TABLE IF NOT EXISTS machine_base_info (
+    sn VARCHAR(255) NOT NULL PRIMARY KEY,
+    ip VARCHAR(15),
+    model VARCHAR(255),
+    operating_system VARCHAR(255),
+    kernel_version VARCHAR(255),
+    cpu VARCHAR(255),
+    memory VARCHAR(255),
+    power VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS machine_disk_info (
+    sn VARCHAR(255),
+    disk VARCHAR(255),
+    capacity VARCHAR(255),
+    media VARCHAR(50),
+    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
+);
+
+CREATE TABLE IF NOT EXISTS machine_memory_info (
+    sn VARCHAR(255),
+    location VARCHAR(255),
+    type VARCHAR(255),
+    size VARCHAR(50),
+    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
+);
+
+CREATE TABLE IF NOT EXISTS machine_raid_info (
+    sn VARCHAR(255),
+    raid_level VARCHAR(50),
+    capacity VARCHAR(255),
+    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
+);
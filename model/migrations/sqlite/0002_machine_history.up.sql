@@ -0,0 +1,13 @@
+-- 采集时间; SQLite 的 ADD COLUMN 没有 IF NOT EXISTS, 与 schema_version 在同一个事务中执行, 失败时不会留下该字段
+ALTER TABLE machine_base_info ADD COLUMN collected_at DATETIME;
+
+-- 重复采集时被替换的旧信息, snapshot 为 Machine_INFO 的json
+CREATE TABLE IF NOT EXISTS machine_info_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sn VARCHAR(255) NOT NULL,
+    collected_at DATETIME,
+    replaced_at DATETIME NOT NULL,
+    snapshot TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sn ON machine_info_history (sn, replaced_at);
@@ -0,0 +1,12 @@
+-- pping 监控模式下的主机状态变化
+CREATE TABLE IF NOT EXISTS machine_ping_event (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip VARCHAR(64) NOT NULL,
+    from_state VARCHAR(16),
+    to_state VARCHAR(16),
+    loss DOUBLE,
+    avg_ms DOUBLE,
+    changed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip ON machine_ping_event (ip, changed_at);
@@ -0,0 +1,18 @@
+-- 机房资产信息, 由运维人员维护, 不要求已经采集过
+CREATE TABLE IF NOT EXISTS idc_machine_info (
+    sn VARCHAR(255) NOT NULL PRIMARY KEY,
+    label VARCHAR(255),
+    external_ip VARCHAR(64),
+    internal_ip VARCHAR(64),
+    idrac_ip VARCHAR(64),
+    service_name VARCHAR(255),
+    service_owner VARCHAR(255),
+    machine_owner VARCHAR(255),
+    leader VARCHAR(255),
+    cabinet VARCHAR(255),
+    U_number VARCHAR(50),
+    machine_model VARCHAR(255),
+    comment TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_service ON idc_machine_info (service_name);
@@ -0,0 +1,3 @@
+-- 硬盘序列号, 同型号硬盘更换后只有序列号不同
+-- 与 0002 相同, 依靠事务保证失败后可以重新执行
+ALTER TABLE machine_disk_info ADD COLUMN serial VARCHAR(255);
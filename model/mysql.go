@@ -0,0 +1,123 @@
+package model
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+var mysqlDialect = &dialect{
+	name:       "mysql",
+	migrations: "migrations/mysql",
+	schemaVersion: `CREATE TABLE IF NOT EXISTS schema_version (
+	version INT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at DATETIME NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	tableExists: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+	upsertBase: `INSERT INTO machine_base_info (sn, ip, model, operating_system, kernel_version, cpu, memory, power, collected_at)
+		VALUES (:sn, :ip, :model, :operating_system, :kernel_version, :cpu, :memory, :power, :collected_at)
+		ON DUPLICATE KEY UPDATE ip = VALUES(ip), model = VALUES(model), operating_system = VALUES(operating_system),
+		kernel_version = VALUES(kernel_version), cpu = VALUES(cpu), memory = VALUES(memory), power = VALUES(power),
+		collected_at = VALUES(collected_at)`,
+	lockRow: "FOR UPDATE",
+}
+
+func openMySQL(cfg Config) (Store, error) {
+	mc, err := mysqlConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := mysql.NewConnector(mc)
+	if err != nil {
+		return nil, fmt.Errorf("model: %w", err)
+	}
+
+	sqlDB := sql.OpenDB(connector)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	ctx := context.Background()
+	if mc.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mc.Timeout)
+		defer cancel()
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("model: connect to [%s] failed, err=%w", mc.Addr, err)
+	}
+
+	return &sqlStore{db: sqlx.NewDb(sqlDB, "mysql"), dialect: mysqlDialect}, nil
+}
+
+// 解析DSN并应用配置中的TLS和超时, 时间字段解析为本地时间
+func mysqlConfig(cfg Config) (*mysql.Config, error) {
+	mc, err := mysql.ParseDSN(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("model: DSN 格式错误, err=%#v", err.Error())
+	}
+
+	mc.ParseTime = true
+	if !strings.Contains(cfg.DSN, "loc=") {
+		mc.Loc = time.Local
+	}
+	if mc.Timeout == 0 {
+		mc.Timeout = cfg.ConnectTimeout
+	}
+
+	t := cfg.TLS
+	if t.Enable || t.CA != "" || t.Cert != "" {
+		host, _, err := net.SplitHostPort(mc.Addr)
+		if err != nil {
+			host = mc.Addr
+		}
+		mc.TLS, err = t.tlsConfig(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mc, nil
+}
+
+func (t TLSConfig) tlsConfig(host string) (*tls.Config, error) {
+	tc := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if tc.ServerName == "" {
+		tc.ServerName = host
+	}
+
+	if t.CA != "" {
+		pem, err := os.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("model: read ca [%s] failed, err=%#v", t.CA, err.Error())
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("model: ca [%s] 中没有证书", t.CA)
+		}
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		if t.Cert == "" || t.Key == "" {
+			return nil, errors.New("model: 客户端证书需要同时配置 cert 和 key")
+		}
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("model: load client cert failed, err=%#v", err.Error())
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// pping 监控模式下的主机状态变化
+type Ping_Event_MODEL struct {
+	IP        string    `db:"ip"`
+	FromState string    `db:"from_state"`
+	ToState   string    `db:"to_state"`
+	Loss      float64   `db:"loss"`
+	AvgMs     float64   `db:"avg_ms"`
+	ChangedAt time.Time `db:"changed_at"`
+}
+
+// 写入一轮ping的状态变化
+func (s *sqlStore) WritePingEvents(events []Ping_Event_MODEL) error {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		fmt.Printf("start tx err, err=%#v", err)
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, e := range events {
+		_, err = tx.NamedExec("INSERT INTO machine_ping_event (ip, from_state, to_state, loss, avg_ms, changed_at) VALUES (:ip, :from_state, :to_state, :loss, :avg_ms, :changed_at)", e)
+		if err != nil {
+			fmt.Printf("insert ping event to db err, err=%#v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -1,53 +1,12 @@
 package model
 
 import (
+	"errors"
 	"fmt"
-
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jmoiron/sqlx"
-)
-
-var (
-	kwDB *sqlx.DB
+	"time"
 )
 
-/*
-```USE idc;
-
-CREATE TABLE  machine_base_info (
-	sn VARCHAR(255) NOT NULL PRIMARY KEY,
-    ip VARCHAR(15),
-    model VARCHAR(255),
-    operating_system VARCHAR(255),
-    kernel_version VARCHAR(255),
-    cpu VARCHAR(255),
-    memory VARCHAR(255),
-	power VARCHAR(255)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
-
-CREATE TABLE machine_disk_info (
-    sn VARCHAR(255),
-    disk VARCHAR(255),
-    capacity VARCHAR(255),
-    media VARCHAR(50),
-    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
-
-CREATE TABLE machine_memory_info (
-    sn VARCHAR(255),
-    location VARCHAR(255),
-    type VARCHAR(255),
-    size VARCHAR(50),
-    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
-
-CREATE TABLE machine_raid_info (
-    sn VARCHAR(255),
-    raid_level VARCHAR(50),
-    capacity VARCHAR(255),
-    FOREIGN KEY (sn) REFERENCES machine_base_info (sn)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;```
-*/
+// 表结构见 migrations 目录, 使用 prun db migrate 创建和升级
 
 type idc_machine_info struct {
 	SN           string `db:"sn"`
@@ -94,6 +53,7 @@ type Machine_Disk_INFO_MODEL struct {
 	Product  string `db:"disk"`
 	Capacity string `db:"capacity"`
 	Media    string `db:"media"`
+	Serial   string `db:"serial"`
 }
 
 type Machine_RAID_INFO_MODEL struct {
@@ -102,21 +62,15 @@ type Machine_RAID_INFO_MODEL struct {
 	Capacity string `db:"capacity"`
 }
 
-func Init() error {
+// 写入一台机器的采集结果, 以sn为主键, 重复采集时更新基本信息并整体替换内存, 磁盘和RAID信息
+// 与已有的信息不同时, 旧的信息保存到 machine_info_history
+func (s *sqlStore) WriteToDB(info Machine_INFO) error {
 
-	dsn := "idc:kuwo@123@tcp(127.0.0.1:3306)/idc?charset=utf8"
-	db, err := sqlx.Connect("mysql", dsn)
-	if err != nil {
-		fmt.Printf("connect to DB error, err=%#v", err)
-		return err
+	if info.Base.SN == "" {
+		return errors.New("model: sn 为空, 不写入数据库")
 	}
-	kwDB = db
-	return nil
-}
-
-func WriteToDB(info Machine_INFO) error {
 
-	tx, err := kwDB.Beginx()
+	tx, err := s.db.Beginx()
 
 	if err != nil {
 		fmt.Printf("start tx err, err=%#v", err)
@@ -125,51 +79,61 @@ func WriteToDB(info Machine_INFO) error {
 
 	defer tx.Rollback()
 
+	now := time.Now()
+
+	// 保存旧的信息
+	err = s.saveHistory(tx, info, now)
+	if err != nil {
+		fmt.Printf("save history to db err, err=%#v", err)
+		return err
+	}
+
 	// 机器基本硬件信息到db
-	base := info.Base
-	_, err = tx.NamedExec("INSERT INTO idc.machine_base_info values (:sn, :ip, :model, :operating_system, :kernel_version, :cpu, :memory, :power)", base)
+	base := struct {
+		Machine_Base_INFO_MODEL
+		CollectedAt time.Time `db:"collected_at"`
+	}{info.Base, now}
+	_, err = tx.NamedExec(s.dialect.upsertBase, base)
 	if err != nil {
-		fmt.Printf("insert base data to db err, err=%#v", err)
+		fmt.Printf("upsert base data to db err, err=%#v", err)
 		return err
 	}
 
+	// 删除上一次采集的内存, 硬盘和Raid信息
+	for _, table := range []string{"machine_memory_info", "machine_disk_info", "machine_raid_info"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE sn = ?", info.Base.SN)
+		if err != nil {
+			fmt.Printf("delete %s data err, err=%#v", table, err)
+			return err
+		}
+	}
+
 	// 内存信息
-	if len(info.Memorys) > 0 {
-		for _, memory := range info.Memorys {
-			_, err = tx.NamedExec("INSERT INTO machine_memory_info VALUES(:sn, :location, :type, :size)", memory)
-			if err != nil {
-				fmt.Printf("insert memory data to db err, err=%#v", err)
-				return err
-			}
+	for _, memory := range info.Memorys {
+		_, err = tx.NamedExec("INSERT INTO machine_memory_info (sn, location, type, size) VALUES (:sn, :location, :type, :size)", memory)
+		if err != nil {
+			fmt.Printf("insert memory data to db err, err=%#v", err)
+			return err
 		}
 	}
+
 	// 硬盘信息
-	if len(info.Disks) > 0 {
-		for _, disk := range info.Disks {
-			_, err = tx.NamedExec("INSERT INTO machine_disk_info VALUES(:sn, :disk, :capacity, :media)", disk)
-			if err != nil {
-				fmt.Printf("insert disk data to db err, err=%#v", err)
-				return err
-			}
+	for _, disk := range info.Disks {
+		_, err = tx.NamedExec("INSERT INTO machine_disk_info (sn, disk, capacity, media, serial) VALUES (:sn, :disk, :capacity, :media, :serial)", disk)
+		if err != nil {
+			fmt.Printf("insert disk data to db err, err=%#v", err)
+			return err
 		}
 	}
 
 	// Raid信息
-	if len(info.Raids) > 0 {
-		for _, raid := range info.Raids {
-			_, err = tx.NamedExec("INSERT INTO machine_raid_info VALUES(:sn, :raid_level, :capacity)", raid)
-			if err != nil {
-				fmt.Printf("insert raid data to db err, err=%#v", err)
-				return err
-			}
+	for _, raid := range info.Raids {
+		_, err = tx.NamedExec("INSERT INTO machine_raid_info (sn, raid_level, capacity) VALUES (:sn, :raid_level, :capacity)", raid)
+		if err != nil {
+			fmt.Printf("insert raid data to db err, err=%#v", err)
+			return err
 		}
 	}
 
-	tx.Commit()
-	return nil
-
-}
-
-func Close() {
-	kwDB.Close()
+	return tx.Commit()
 }
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	// sqlx 不认识 modernc 的驱动名, NamedExec 使用 ? 占位符
+	sqlx.BindDriver("sqlite", sqlx.QUESTION)
+}
+
+var sqliteDialect = &dialect{
+	name:       "sqlite",
+	migrations: "migrations/sqlite",
+	schemaVersion: `CREATE TABLE IF NOT EXISTS schema_version (
+	version INT NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at DATETIME NOT NULL
+)`,
+	tableExists: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?",
+	upsertBase: `INSERT INTO machine_base_info (sn, ip, model, operating_system, kernel_version, cpu, memory, power, collected_at)
+		VALUES (:sn, :ip, :model, :operating_system, :kernel_version, :cpu, :memory, :power, :collected_at)
+		ON CONFLICT (sn) DO UPDATE SET ip = excluded.ip, model = excluded.model, operating_system = excluded.operating_system,
+		kernel_version = excluded.kernel_version, cpu = excluded.cpu, memory = excluded.memory, power = excluded.power,
+		collected_at = excluded.collected_at`,
+	// 只有一个连接, 事务之间不会并发
+	lockRow: "",
+	txDDL:   true,
+}
+
+// 打开SQLite数据库文件, 文件不存在时创建
+// sqlite:///var/lib/zeus/idc.db, sqlite://idc.db 或 sqlite::memory:
+func openSQLite(cfg Config) (Store, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(cfg.DSN, "sqlite:"), "//")
+	if path == "" {
+		return nil, fmt.Errorf("model: SQLite DSN 缺少文件路径 [%s]", cfg.DSN)
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	dsn := "file:" + path + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+	db, err := sqlx.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("model: %w", err)
+	}
+	// 内存数据库每个连接都是独立的, 文件数据库也避免写锁冲突
+	db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	if cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("model: open [%s] failed, err=%w", path, err)
+	}
+
+	return &sqlStore{db: db, dialect: sqliteDialect}, nil
+}
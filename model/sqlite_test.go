@@ -0,0 +1,225 @@
+package model
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) Store {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.DSN = "sqlite://" + filepath.Join(t.TempDir(), "idc.db")
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	if _, err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testMachine() Machine_INFO {
+	return Machine_INFO{
+		Base: Machine_Base_INFO_MODEL{SN: "7XK2N33", IP: "10.0.0.2", Model: "PowerEdge R740", OS: "CentOS 7.9", KernelVersion: "3.10.0", Memory: "32000 MB"},
+		Memorys: []Machine_Memory_INFO_MODEL{
+			{SN: "7XK2N33", Location: "A1", Type: "DDR4", Size: "16384 MB"},
+			{SN: "7XK2N33", Location: "A2", Type: "DDR4", Size: "16384 MB"},
+		},
+		Disks: []Machine_Disk_INFO_MODEL{{SN: "7XK2N33", Product: "ST600MM0088", Capacity: "558.38 GB", Media: "HDD", Serial: "W420J4XL"}},
+		Raids: []Machine_RAID_INFO_MODEL{{SN: "7XK2N33", Level: "RAID-1", Capacity: "558.38 GB"}},
+	}
+}
+
+func TestSQLiteMigrate(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.CheckSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := s.Migrate(); err != nil || len(done) != 0 {
+		t.Fatalf("second migrate = %v, err=%v", done, err)
+	}
+
+	done, err := s.Rollback(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 || done[0].Name != "disk_serial" || done[1].Name != "idc_machine_info" {
+		t.Errorf("rollback = %v", done)
+	}
+	if err := s.CheckSchema(); err == nil {
+		t.Error("CheckSchema should fail after rollback")
+	}
+
+	// 全部回滚后重新升级
+	if _, err := s.Rollback(10); err != nil {
+		t.Fatal(err)
+	}
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range status {
+		if m.Applied {
+			t.Errorf("%04d_%s still applied", m.Version, m.Name)
+		}
+	}
+	if done, err := s.Migrate(); err != nil || len(done) != len(status) {
+		t.Fatalf("migrate = %v, err=%v", done, err)
+	}
+}
+
+// 脚本中的语句失败时, 之前的DDL和 schema_version 都回滚
+func TestSQLiteMigrationRollbackOnError(t *testing.T) {
+	s := openTestStore(t).(*sqlStore)
+
+	script := "ALTER TABLE machine_disk_info ADD COLUMN broken INT;\nSELECT * FROM no_such_table;"
+	if err := s.execMigration(99, "broken", script, true); err == nil {
+		t.Fatal("migration should fail")
+	}
+
+	var n int
+	if err := s.db.Get(&n, "SELECT COUNT(*) FROM pragma_table_info('machine_disk_info') WHERE name = 'broken'"); err != nil || n != 0 {
+		t.Errorf("column added by failed migration, n=%d err=%v", n, err)
+	}
+	if err := s.db.Get(&n, "SELECT COUNT(*) FROM schema_version WHERE version = 99"); err != nil || n != 0 {
+		t.Errorf("failed migration recorded, n=%d err=%v", n, err)
+	}
+	if err := s.execMigration(99, "broken", "ALTER TABLE machine_disk_info ADD COLUMN broken INT;", true); err != nil {
+		t.Errorf("retry after rollback: %v", err)
+	}
+}
+
+// 查询状态不创建 schema_version, 只读账号也能使用
+func TestSQLiteStatusReadOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DSN = "sqlite://" + filepath.Join(t.TempDir(), "idc.db")
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	status, err := s.MigrationStatus()
+	if err != nil || len(status) == 0 {
+		t.Fatalf("status = %v, err=%v", status, err)
+	}
+	for _, m := range status {
+		if m.Applied {
+			t.Errorf("%04d_%s applied on empty database", m.Version, m.Name)
+		}
+	}
+	if err := s.CheckSchema(); err == nil {
+		t.Error("CheckSchema should fail on empty database")
+	}
+
+	var n int
+	if err := s.(*sqlStore).db.Get(&n, sqliteDialect.tableExists, "schema_version"); err != nil || n != 0 {
+		t.Errorf("schema_version created by status, n=%d err=%v", n, err)
+	}
+}
+
+func TestSQLiteWriteToDB(t *testing.T) {
+	s := openTestStore(t)
+
+	info := testMachine()
+	if err := s.WriteToDB(info); err != nil {
+		t.Fatal(err)
+	}
+	// 重复采集相同的信息不产生历史
+	if err := s.WriteToDB(info); err != nil {
+		t.Fatal(err)
+	}
+	if history, err := s.QueryHistory(info.Base.SN); err != nil || len(history) != 0 {
+		t.Fatalf("history = %v, err=%v", history, err)
+	}
+
+	cur := testMachine()
+	cur.Base.KernelVersion = "4.18.0"
+	cur.Disks = []Machine_Disk_INFO_MODEL{{SN: "7XK2N33", Product: "ST600MM0099", Capacity: "558.38 GB", Media: "HDD"}}
+	if err := s.WriteToDB(cur); err != nil {
+		t.Fatal(err)
+	}
+
+	got, found, err := s.QueryMachine(info.Base.SN)
+	if err != nil || !found {
+		t.Fatalf("found=%v err=%v", found, err)
+	}
+	if !reflect.DeepEqual(got, cur) {
+		t.Errorf("stored =\n%+v\nwant\n%+v", got, cur)
+	}
+
+	history, err := s.QueryHistory(info.Base.SN)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("history = %v, err=%v", history, err)
+	}
+	old, err := history[0].Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(old, info) {
+		t.Errorf("snapshot =\n%+v\nwant\n%+v", old, info)
+	}
+	if !history[0].CollectedAt.Valid || time.Since(history[0].ReplacedAt) > time.Minute {
+		t.Errorf("history times = %v %v", history[0].CollectedAt, history[0].ReplacedAt)
+	}
+
+	if _, found, err := s.QueryMachine("missing"); err != nil || found {
+		t.Errorf("missing sn found=%v err=%v", found, err)
+	}
+}
+
+func TestSQLiteQueryHosts(t *testing.T) {
+	s := openTestStore(t)
+
+	if err := s.WriteToDB(testMachine()); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.(*sqlStore).db.Exec("INSERT INTO idc_machine_info (sn, label, service_name, cabinet) VALUES (?, ?, ?, ?)", "7XK2N33", "web-01", "web", "B12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := s.QueryHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Machine_Host_MODEL{{SN: "7XK2N33", IP: "10.0.0.2", Model: "PowerEdge R740", Label: "web-01", ServiceName: "web", Cabinet: "B12"}}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts = %+v", hosts)
+	}
+
+	err = s.WritePingEvents([]Ping_Event_MODEL{{IP: "10.0.0.2", FromState: "up", ToState: "down", Loss: 100, ChangedAt: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenScheme(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DSN = "postgres://idc@10.0.0.1/idc"
+	if _, err := Open(cfg); err == nil {
+		t.Error("unsupported scheme should fail")
+	}
+
+	cfg.DSN = "sqlite:"
+	if _, err := Open(cfg); err == nil {
+		t.Error("sqlite without path should fail")
+	}
+
+	cfg.DSN = "sqlite::memory:"
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
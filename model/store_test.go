@@ -0,0 +1,87 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.yaml")
+	os.WriteFile(path, []byte("dsn: idc:file@tcp(10.0.0.1:3306)/idc\nmax_open_conns: 20\nconnect_timeout: 2s\ntls:\n  enable: true\n"), 0600)
+
+	t.Setenv(ENV_DB_DSN, "")
+	t.Setenv(ENV_DB_CONFIG, "")
+
+	cfg, err := LoadConfig("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DSN != "idc:file@tcp(10.0.0.1:3306)/idc" || cfg.MaxOpenConns != 20 || cfg.ConnectTimeout != 2*time.Second || !cfg.TLS.Enable {
+		t.Errorf("file config = %+v", cfg)
+	}
+	// 没有配置的使用默认值
+	if cfg.MaxIdleConns != DefaultConfig().MaxIdleConns {
+		t.Errorf("MaxIdleConns = %d", cfg.MaxIdleConns)
+	}
+
+	// 环境变量覆盖配置文件, 参数覆盖环境变量
+	t.Setenv(ENV_DB_CONFIG, path)
+	t.Setenv(ENV_DB_DSN, "idc:env@tcp(10.0.0.2:3306)/idc")
+	cfg, err = LoadConfig("", "")
+	if err != nil || cfg.DSN != "idc:env@tcp(10.0.0.2:3306)/idc" || cfg.MaxOpenConns != 20 {
+		t.Errorf("env config = %+v, err=%v", cfg, err)
+	}
+	cfg, err = LoadConfig("idc:flag@tcp(10.0.0.3:3306)/idc", "")
+	if err != nil || cfg.DSN != "idc:flag@tcp(10.0.0.3:3306)/idc" {
+		t.Errorf("flag config = %+v, err=%v", cfg, err)
+	}
+
+	t.Setenv(ENV_DB_CONFIG, "")
+	t.Setenv(ENV_DB_DSN, "")
+	if _, err := LoadConfig("", ""); err == nil {
+		t.Error("missing dsn should fail")
+	}
+
+	os.WriteFile(path, []byte("dsn: x\npassword: y\n"), 0600)
+	if _, err := LoadConfig("", path); err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("unknown field err = %v", err)
+	}
+}
+
+func TestMysqlConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DSN = "idc:secret@tcp(db.example.com:3306)/idc?charset=utf8"
+	cfg.TLS.Enable = true
+
+	mc, err := mysqlConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mc.ParseTime || mc.Loc != time.Local || mc.Timeout != cfg.ConnectTimeout {
+		t.Errorf("ParseTime=%v Loc=%v Timeout=%v", mc.ParseTime, mc.Loc, mc.Timeout)
+	}
+	if mc.TLS == nil || mc.TLS.ServerName != "db.example.com" {
+		t.Errorf("TLS = %+v", mc.TLS)
+	}
+
+	// DSN 中的参数优先
+	cfg.DSN = "idc:secret@tcp(db.example.com:3306)/idc?timeout=1s&loc=UTC"
+	cfg.TLS = TLSConfig{}
+	mc, err = mysqlConfig(cfg)
+	if err != nil || mc.Timeout != time.Second || mc.Loc != time.UTC || mc.TLS != nil {
+		t.Errorf("mc = %+v, err=%v", mc, err)
+	}
+
+	cfg.TLS = TLSConfig{Cert: "client.pem"}
+	if _, err := mysqlConfig(cfg); err == nil {
+		t.Error("cert without key should fail")
+	}
+
+	cfg.DSN = "idc:secret@tcp(db.example.com:3306"
+	if _, err := Open(cfg); err == nil {
+		t.Error("invalid dsn should fail")
+	}
+}
@@ -0,0 +1,101 @@
+package parallelping
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"zeus/gate"
+)
+
+// ExecPinger 调用系统的ping命令, 每台主机一个进程
+// 没有ICMP socket权限时使用
+type ExecPinger struct {
+	// 每台主机发送的包数, 默认3
+	Count int
+	// 同时运行的ping进程数量, 默认450
+	Parallel int
+	// 每秒启动的ping进程数量, 0 表示不限制
+	Rate float64
+}
+
+var (
+	// 3 packets transmitted, 2 received, 33.3333% packet loss, time 2003ms
+	transmittedRe = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (?:packets )?received`)
+	// rtt min/avg/max/mdev = 0.041/0.052/0.066/0.010 ms
+	rttRe = regexp.MustCompile(`= ([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+) ms`)
+)
+
+func (p *ExecPinger) Ping(ctx context.Context, hosts []string) ([]Stats, error) {
+	count := p.Count
+	if count <= 0 {
+		count = 3
+	}
+
+	var wg sync.WaitGroup
+	limits := gate.NewLimits(gate.New(parallel(p.Parallel)), gate.NewLimiter(p.Rate, 1), gate.Limit{}, gate.Limit{})
+	res := make([]Stats, len(hosts))
+
+	for i, ip := range hosts {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			release, err := limits.Acquire(ctx, ip, "")
+			if err != nil {
+				res[i] = Stats{IP: ip, Probe: PROBE_ICMP, Loss: 100, Err: err}
+				return
+			}
+			defer release()
+			res[i] = execPing(ctx, ip, count)
+		}(i, ip)
+	}
+	wg.Wait()
+
+	return res, nil
+}
+
+func execPing(ctx context.Context, ip string, count int) Stats {
+	s := Stats{IP: ip, Probe: PROBE_ICMP, Sent: count}
+
+	cmd := exec.CommandContext(ctx, "ping", "-c"+strconv.Itoa(count), ip)
+	// 固定输出格式, 不受系统语言影响
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+
+	parsePingOutput(&s, string(out))
+	if s.Recv == 0 && err == nil {
+		// 无法解析输出, 只能根据退出码判断
+		s.Recv = s.Sent
+	}
+	if s.Recv == 0 {
+		s.Loss = 100
+		if _, ok := err.(*exec.ExitError); !ok {
+			// ping命令不存在等错误
+			s.Err = err
+		}
+	}
+	return s
+}
+
+// 解析ping命令的统计信息
+func parsePingOutput(s *Stats, out string) {
+	if m := transmittedRe.FindStringSubmatch(out); m != nil {
+		s.Sent, _ = strconv.Atoi(m[1])
+		s.Recv, _ = strconv.Atoi(m[2])
+		if s.Sent > 0 {
+			s.Loss = float64(s.Sent-s.Recv) * 100 / float64(s.Sent)
+		}
+	}
+
+	if m := rttRe.FindStringSubmatch(out); m != nil {
+		ms := func(v string) time.Duration {
+			f, _ := strconv.ParseFloat(v, 64)
+			return time.Duration(f * float64(time.Millisecond))
+		}
+		s.Min, s.Avg, s.Max, s.StdDev = ms(m[1]), ms(m[2]), ms(m[3]), ms(m[4])
+	}
+}
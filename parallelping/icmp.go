@@ -0,0 +1,307 @@
+package parallelping
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"zeus/gate"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protoICMP   = 1
+	protoICMPv6 = 58
+)
+
+// ICMPPinger 不依赖系统ping命令, 直接发送ICMP echo
+// 所有主机共用一个IPv4 socket和一个IPv6 socket, 每轮向所有主机各发一个包
+type ICMPPinger struct {
+	// 每台主机发送的包数, 默认3
+	Count int
+	// 两轮发送之间的间隔, 默认1s
+	Interval time.Duration
+	// 最后一轮发出后等待回复的时间, 默认2s
+	Timeout time.Duration
+	// 每秒发送的包数, 0 表示不限制
+	Rate float64
+	// 只使用raw socket(需要root或CAP_NET_RAW)
+	// 否则先尝试无特权的datagram socket(需要 net.ipv4.ping_group_range 包含当前用户), 失败再使用raw socket
+	Privileged bool
+}
+
+// 一个已发出, 还没收到回复的包
+type probeKey struct {
+	ip  string
+	seq int
+}
+
+type probe struct {
+	host int
+	sent time.Time
+}
+
+// 一个地址族的socket
+type icmpConn struct {
+	conn  *icmp.PacketConn
+	proto int
+	echo  icmp.Type
+	reply icmp.Type
+	// datagram socket的ID由内核改写并按socket分发回复, 不需要校验
+	raw bool
+	id  int
+	seq int
+}
+
+func (p *ICMPPinger) defaults() (count int, interval, timeout time.Duration) {
+	count, interval, timeout = p.Count, p.Interval, p.Timeout
+	if count <= 0 {
+		count = 3
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return
+}
+
+// Ping 结果与hosts的顺序一致; 无法创建socket时返回错误
+func (p *ICMPPinger) Ping(ctx context.Context, hosts []string) ([]Stats, error) {
+	count, interval, timeout := p.defaults()
+
+	res := make([]Stats, len(hosts))
+	addrs := make([]*net.IPAddr, len(hosts))
+	var need4, need6 bool
+	for i, h := range hosts {
+		res[i].IP = h
+		res[i].Probe = PROBE_ICMP
+		addr, err := net.ResolveIPAddr("ip", h)
+		if err != nil {
+			res[i].Err = err
+			continue
+		}
+		addrs[i] = addr
+		if addr.IP.To4() != nil {
+			need4 = true
+		} else {
+			need6 = true
+		}
+	}
+
+	var c4, c6 *icmpConn
+	var err error
+	if need4 {
+		if c4, err = p.listen(false); err != nil {
+			return nil, err
+		}
+		defer c4.conn.Close()
+	}
+	if need6 {
+		if c6, err = p.listen(true); err != nil {
+			return nil, err
+		}
+		defer c6.conn.Close()
+	}
+
+	var (
+		mu      sync.Mutex
+		pending = make(map[probeKey]probe)
+		rtts    = make([][]time.Duration, len(hosts))
+		// 所有包都已发出并收到回复时提前结束
+		allDone = make(chan struct{})
+		sentAll bool
+		closed  bool
+	)
+	finish := func() {
+		if sentAll && len(pending) == 0 && !closed {
+			closed = true
+			close(allDone)
+		}
+	}
+
+	onReply := func(ip string, seq int) {
+		now := time.Now()
+		mu.Lock()
+		defer mu.Unlock()
+
+		k := probeKey{ip, seq}
+		pr, ok := pending[k]
+		if !ok {
+			// 重复的回复, 或者已经超时
+			return
+		}
+		delete(pending, k)
+		rtts[pr.host] = append(rtts[pr.host], now.Sub(pr.sent))
+		finish()
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range []*icmpConn{c4, c6} {
+		if c == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(c *icmpConn) {
+			defer wg.Done()
+			c.receive(onReply)
+		}(c)
+	}
+
+	send := func(i int) {
+		c := c4
+		if addrs[i].IP.To4() == nil {
+			c = c6
+		}
+
+		mu.Lock()
+		c.seq = (c.seq + 1) & 0xffff
+		seq := c.seq
+		pending[probeKey{addrs[i].IP.String(), seq}] = probe{host: i, sent: time.Now()}
+		res[i].Sent++
+		mu.Unlock()
+
+		if err := c.send(addrs[i], seq); err != nil {
+			// 发送失败(例如没有路由)记为丢包
+			mu.Lock()
+			delete(pending, probeKey{addrs[i].IP.String(), seq})
+			if res[i].Err == nil {
+				res[i].Err = err
+			}
+			mu.Unlock()
+		}
+	}
+
+	limiter := gate.NewLimiter(p.Rate, 1)
+sending:
+	for round := 0; round < count; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				break sending
+			case <-time.After(interval):
+			}
+		}
+		for i := range hosts {
+			if addrs[i] == nil {
+				continue
+			}
+			if limiter.Wait(ctx) != nil {
+				break sending
+			}
+			send(i)
+		}
+	}
+
+	mu.Lock()
+	sentAll = true
+	finish()
+	mu.Unlock()
+
+	select {
+	case <-allDone:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+	}
+
+	// 关闭socket让接收goroutine退出
+	for _, c := range []*icmpConn{c4, c6} {
+		if c != nil {
+			c.conn.Close()
+		}
+	}
+	wg.Wait()
+
+	for i := range res {
+		res[i].setRTT(rtts[i])
+	}
+	return res, nil
+}
+
+// 创建socket, 见 ICMPPinger.Privileged
+func (p *ICMPPinger) listen(v6 bool) (*icmpConn, error) {
+	c := &icmpConn{
+		proto: protoICMP,
+		echo:  ipv4.ICMPTypeEcho,
+		reply: ipv4.ICMPTypeEchoReply,
+		id:    rand.Intn(0xffff),
+	}
+	network, rawNetwork, laddr := "udp4", "ip4:icmp", "0.0.0.0"
+	if v6 {
+		c.proto, c.echo, c.reply = protoICMPv6, ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		network, rawNetwork, laddr = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	var err error
+	if !p.Privileged {
+		if c.conn, err = icmp.ListenPacket(network, laddr); err == nil {
+			return c, nil
+		}
+	}
+
+	c.raw = true
+	if c.conn, err = icmp.ListenPacket(rawNetwork, laddr); err != nil {
+		return nil, fmt.Errorf("parallelping: 创建ICMP socket失败, 需要root权限或设置 net.ipv4.ping_group_range, err=%#v", err.Error())
+	}
+	return c, nil
+}
+
+func (c *icmpConn) send(addr *net.IPAddr, seq int) error {
+	msg := icmp.Message{
+		Type: c.echo,
+		Body: &icmp.Echo{ID: c.id, Seq: seq, Data: []byte("zeus-parallelping")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	var dst net.Addr = addr
+	if !c.raw {
+		dst = &net.UDPAddr{IP: addr.IP, Zone: addr.Zone}
+	}
+	_, err = c.conn.WriteTo(b, dst)
+	return err
+}
+
+// 读取回复直到socket关闭
+func (c *icmpConn) receive(onReply func(ip string, seq int)) {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		msg, err := icmp.ParseMessage(c.proto, buf[:n])
+		if err != nil || msg.Type != c.reply {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || (c.raw && echo.ID != c.id) {
+			// raw socket 会收到本机所有的ICMP回复
+			continue
+		}
+
+		var ip net.IP
+		switch a := peer.(type) {
+		case *net.IPAddr:
+			ip = a.IP
+		case *net.UDPAddr:
+			ip = a.IP
+		default:
+			continue
+		}
+		onReply(ip.String(), echo.Seq)
+	}
+}
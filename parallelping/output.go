@@ -0,0 +1,200 @@
+package parallelping
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// 输出格式
+const (
+	FORMAT_TEXT   = "text"
+	FORMAT_TABLE  = "table"
+	FORMAT_JSON   = "json"
+	FORMAT_CSV    = "csv"
+	FORMAT_NDJSON = "ndjson"
+)
+
+// Summary 汇总
+type Summary struct {
+	Total int `json:"total"`
+	Up    int `json:"up"`
+	Down  int `json:"down"`
+}
+
+// Summarize 统计可达和不可达的主机数量
+func Summarize(res []Stats) Summary {
+	s := Summary{Total: len(res)}
+	for _, v := range res {
+		if v.OK() {
+			s.Up++
+		} else {
+			s.Down++
+		}
+	}
+	return s
+}
+
+// SortByIP 按IP排序, IPv4在IPv6之前, 无法解析为IP的主机名排在最后
+// 带端口的主机按IP排序, IP相同时按原来的顺序
+func SortByIP(res []Stats) {
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := parseHostIP(res[i].IP), parseHostIP(res[j].IP)
+		switch {
+		case a == nil && b == nil:
+			return res[i].IP < res[j].IP
+		case a == nil || b == nil:
+			return b == nil
+		}
+		a4, b4 := a.To4(), b.To4()
+		if (a4 == nil) != (b4 == nil) {
+			return a4 != nil
+		}
+		return bytes.Compare(a.To16(), b.To16()) < 0
+	})
+}
+
+// 去掉端口后解析IP
+func parseHostIP(entry string) net.IP {
+	if host, _, err := net.SplitHostPort(entry); err == nil {
+		entry = host
+	}
+	return net.ParseIP(entry)
+}
+
+// JSON/CSV 中的一条记录, 时间单位为毫秒
+type record struct {
+	IP       string  `json:"ip"`
+	Probe    string  `json:"probe"`
+	Up       bool    `json:"up"`
+	Sent     int     `json:"sent"`
+	Recv     int     `json:"recv"`
+	Loss     float64 `json:"loss"`
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	Banner   string  `json:"banner,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func toRecord(s Stats) record {
+	r := record{
+		IP:       s.IP,
+		Probe:    s.Probe,
+		Up:       s.OK(),
+		Sent:     s.Sent,
+		Recv:     s.Recv,
+		Loss:     s.Loss,
+		MinMs:    ms(s.Min),
+		AvgMs:    ms(s.Avg),
+		MaxMs:    ms(s.Max),
+		StdDevMs: ms(s.StdDev),
+		Banner:   s.Banner,
+	}
+	if s.Err != nil {
+		r.Error = s.Err.Error()
+	}
+	return r
+}
+
+// 毫秒, 保留3位小数
+func ms(d time.Duration) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), 64)
+	return v
+}
+
+var csvHeader = []string{"ip", "probe", "up", "sent", "recv", "loss", "min_ms", "avg_ms", "max_ms", "stddev_ms", "banner", "error"}
+
+// Write 按指定格式输出结果
+// text 与原来的输出相同; table 在末尾输出汇总; json 输出包含汇总的单个对象
+// csv 和 ndjson 每台主机一行, 不包含汇总
+func Write(w io.Writer, format string, res []Stats) error {
+	switch format {
+	case FORMAT_TEXT, "":
+		for _, v := range res {
+			ret := "failed"
+			if v.OK() {
+				ret = "success"
+			}
+			fmt.Fprintf(w, "%s\t\t[%s]\n", v.IP, ret)
+		}
+		return nil
+
+	case FORMAT_TABLE:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "IP\tPROBE\tSTATUS\tSENT\tRECV\tLOSS\tMIN\tAVG\tMAX\tSTDDEV\tERROR")
+		for _, v := range res {
+			r := toRecord(v)
+			status := "down"
+			if r.Up {
+				status = "up"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.1f%%\t%.3f\t%.3f\t%.3f\t%.3f\t%s\n",
+				r.IP, r.Probe, status, r.Sent, r.Recv, r.Loss, r.MinMs, r.AvgMs, r.MaxMs, r.StdDevMs, r.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		return WriteSummary(w, Summarize(res))
+
+	case FORMAT_JSON:
+		out := struct {
+			Results []record `json:"results"`
+			Summary Summary  `json:"summary"`
+		}{Results: make([]record, 0, len(res)), Summary: Summarize(res)}
+		for _, v := range res {
+			out.Results = append(out.Results, toRecord(v))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+
+	case FORMAT_NDJSON:
+		enc := json.NewEncoder(w)
+		for _, v := range res {
+			if err := enc.Encode(toRecord(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case FORMAT_CSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, v := range res {
+			r := toRecord(v)
+			cw.Write([]string{
+				r.IP,
+				r.Probe,
+				strconv.FormatBool(r.Up),
+				strconv.Itoa(r.Sent),
+				strconv.Itoa(r.Recv),
+				strconv.FormatFloat(r.Loss, 'f', -1, 64),
+				strconv.FormatFloat(r.MinMs, 'f', -1, 64),
+				strconv.FormatFloat(r.AvgMs, 'f', -1, 64),
+				strconv.FormatFloat(r.MaxMs, 'f', -1, 64),
+				strconv.FormatFloat(r.StdDevMs, 'f', -1, 64),
+				r.Banner,
+				r.Error,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	return fmt.Errorf("parallelping: 未知的输出格式 %q", format)
+}
+
+// WriteSummary 输出一行汇总
+func WriteSummary(w io.Writer, s Summary) error {
+	_, err := fmt.Fprintf(w, "总数: %d, 可达: %d, 不可达: %d\n", s.Total, s.Up, s.Down)
+	return err
+}
@@ -0,0 +1,92 @@
+package parallelping
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStats() []Stats {
+	return []Stats{
+		{IP: "10.0.0.10", Probe: PROBE_ICMP, Sent: 3, Recv: 3, Min: time.Millisecond, Avg: 1500 * time.Microsecond, Max: 2 * time.Millisecond},
+		{IP: "[::1]:22", Probe: "ssh:22", Sent: 3, Recv: 3, Banner: "SSH-2.0-OpenSSH_8.0"},
+		{IP: "bad.invalid", Err: errors.New("no such host")},
+		{IP: "10.0.0.9", Sent: 3, Loss: 100},
+	}
+}
+
+func TestSortByIP(t *testing.T) {
+	res := testStats()
+	SortByIP(res)
+
+	var got []string
+	for _, v := range res {
+		got = append(got, v.IP)
+	}
+	if want := []string{"10.0.0.9", "10.0.0.10", "[::1]:22", "bad.invalid"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("sorted = %v, want %v", got, want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FORMAT_JSON, testStats()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Results []record
+		Summary Summary
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Summary != (Summary{Total: 4, Up: 2, Down: 2}) {
+		t.Errorf("summary = %+v", out.Summary)
+	}
+	if r := out.Results[0]; !r.Up || r.AvgMs != 1.5 || r.MaxMs != 2 {
+		t.Errorf("first record = %+v", r)
+	}
+	if r := out.Results[2]; r.Up || r.Error != "no such host" {
+		t.Errorf("third record = %+v", r)
+	}
+}
+
+func TestWriteNDJSONAndCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, FORMAT_NDJSON, testStats()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("ndjson lines = %d", len(lines))
+	}
+	var r record
+	if err := json.Unmarshal([]byte(lines[3]), &r); err != nil || r.IP != "10.0.0.9" || r.Loss != 100 {
+		t.Fatalf("ndjson record = %+v, %v", r, err)
+	}
+
+	buf.Reset()
+	if err := Write(&buf, FORMAT_CSV, testStats()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("csv rows = %v", rows)
+	}
+	if want := []string{"10.0.0.10", "icmp", "true", "3", "3", "0", "1", "1.5", "2", "0", "", ""}; !reflect.DeepEqual(rows[1], want) {
+		t.Errorf("csv row = %v, want %v", rows[1], want)
+	}
+
+	if err := Write(&buf, "xml", nil); err == nil {
+		t.Error("unknown format should fail")
+	}
+}
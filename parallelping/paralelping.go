@@ -2,89 +2,42 @@ package parallelping
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
-	"sync"
-
-	"zeus/gate"
+	"strings"
 )
 
-type pingResult struct {
-	IP string
-	ok bool
-}
-
-func parseIPFromFile(ipfile string) []string {
+// ReadIPFile 读取IP列表文件, 每行一个IP, 忽略空行和#开头的注释
+func ReadIPFile(ipfile string) ([]string, error) {
 	ips := []string{}
 
 	f, err := os.Open(ipfile)
 	if err != nil {
-		fmt.Printf("读取IP列表文件: [%s] 失败, err=%#v\n", ipfile, err)
-		return ips
+		return nil, fmt.Errorf("读取IP列表文件: [%s] 失败, err=%#v", ipfile, err.Error())
 	}
 
 	defer f.Close()
 
-	reader := bufio.NewReader(f)
-	for {
-		ip, err := reader.ReadString('\n')
-		if err != nil {
-			break
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ip := strings.TrimSpace(scanner.Text())
+		if ip == "" || strings.HasPrefix(ip, "#") {
+			continue
 		}
-		ip = ip[:len(ip)-1]
 		ips = append(ips, ip)
 	}
-	return ips
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取IP列表文件: [%s] 失败, err=%#v", ipfile, err.Error())
+	}
+	return ips, nil
 }
 
-func ParallelPing(ipfile string) {
-
-	var wg sync.WaitGroup
-	var w sync.WaitGroup
-	g := gate.New(450)
-
-	resChan := make(chan pingResult, 2000)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		for _, ip := range parseIPFromFile(ipfile) {
-			w.Add(1)
-
-			go func(ip string) {
-				defer func() {
-					w.Done()
-					g.Leave()
-				}()
-				g.Enter()
-				tmp := pingResult{IP: ip, ok: false}
-				cmd := exec.Command("ping", "-c3", ip)
-				if _, err := cmd.Output(); err == nil {
-					tmp.ok = true
-				}
-				resChan <- tmp
-			}(ip)
-		}
-		w.Wait()
-
-	}()
-
-	go func() {
-		wg.Wait()
-		close(resChan)
-	}()
-
-	ret := ""
-	for v := range resChan {
-
-		if v.ok {
-			ret = "success"
-		} else {
-			ret = "failed"
-		}
-
-		fmt.Printf("%s\t\t[%s]\n", v.IP, ret)
+// ParallelPing ping文件中的所有IP, 结果按文件中的顺序排列
+func ParallelPing(ctx context.Context, ipfile string, p Pinger) ([]Stats, error) {
+	ips, err := ReadIPFile(ipfile)
+	if err != nil {
+		return nil, err
 	}
+	return p.Ping(ctx, ips)
 }
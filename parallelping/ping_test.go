@@ -0,0 +1,103 @@
+package parallelping
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSetRTT(t *testing.T) {
+	s := Stats{Sent: 4}
+	s.setRTT([]time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond})
+
+	if s.Recv != 3 || s.Loss != 25 {
+		t.Fatalf("recv = %d, loss = %v", s.Recv, s.Loss)
+	}
+	if s.Min != 10*time.Millisecond || s.Avg != 20*time.Millisecond || s.Max != 30*time.Millisecond {
+		t.Fatalf("min/avg/max = %v/%v/%v", s.Min, s.Avg, s.Max)
+	}
+	// sqrt(200/3) ms
+	if s.StdDev < 8160*time.Microsecond || s.StdDev > 8170*time.Microsecond {
+		t.Fatalf("stddev = %v", s.StdDev)
+	}
+
+	lost := Stats{Sent: 3}
+	lost.setRTT(nil)
+	if lost.OK() || lost.Loss != 100 {
+		t.Fatalf("all lost = %+v", lost)
+	}
+
+	// 无法解析等没有发出的主机
+	unsent := Stats{}
+	unsent.setRTT(nil)
+	if unsent.Loss != 100 {
+		t.Fatalf("unsent = %+v", unsent)
+	}
+}
+
+func TestParsePingOutput(t *testing.T) {
+	out := `PING 10.0.0.1 (10.0.0.1) 56(84) bytes of data.
+64 bytes from 10.0.0.1: icmp_seq=1 ttl=64 time=0.041 ms
+64 bytes from 10.0.0.1: icmp_seq=3 ttl=64 time=0.066 ms
+
+--- 10.0.0.1 ping statistics ---
+3 packets transmitted, 2 received, 33.3333% packet loss, time 2003ms
+rtt min/avg/max/mdev = 0.041/0.052/0.066/0.010 ms
+`
+	var s Stats
+	parsePingOutput(&s, out)
+	if s.Sent != 3 || s.Recv != 2 || s.Loss < 33.3 || s.Loss > 33.4 {
+		t.Fatalf("sent/recv/loss = %d/%d/%v", s.Sent, s.Recv, s.Loss)
+	}
+	if s.Min != 41*time.Microsecond || s.Avg != 52*time.Microsecond || s.Max != 66*time.Microsecond || s.StdDev != 10*time.Microsecond {
+		t.Fatalf("rtt = %v/%v/%v/%v", s.Min, s.Avg, s.Max, s.StdDev)
+	}
+}
+
+func TestICMPPingerLoopback(t *testing.T) {
+	p := &ICMPPinger{Count: 2, Interval: 10 * time.Millisecond, Timeout: time.Second}
+
+	res, err := p.Ping(context.Background(), []string{"127.0.0.1", "127.0.0.1", "no-such-host.invalid"})
+	if err != nil {
+		t.Skipf("ICMP socket not available: %v", err)
+	}
+	if len(res) != 3 {
+		t.Fatalf("got %d results", len(res))
+	}
+
+	for _, s := range res[:2] {
+		if !s.OK() || s.Sent != 2 || s.Recv != 2 || s.Loss != 0 {
+			t.Errorf("127.0.0.1 = %+v", s)
+		}
+		if s.Min <= 0 || s.Min > s.Max {
+			t.Errorf("rtt = %v/%v", s.Min, s.Max)
+		}
+	}
+	if res[2].OK() || res[2].Err == nil {
+		t.Errorf("unresolvable host = %+v", res[2])
+	}
+}
+
+func TestExecPingerRate(t *testing.T) {
+	// 第二个ping进程要等10秒才能启动, 等待期间取消
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	p := &ExecPinger{Count: 1, Rate: 0.1}
+	res, err := p.Ping(ctx, []string{"127.0.0.1", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := 0
+	for _, s := range res {
+		if s.Err == context.DeadlineExceeded {
+			limited++
+			if s.Loss != 100 {
+				t.Errorf("limited host = %+v", s)
+			}
+		}
+	}
+	if limited != 1 {
+		t.Errorf("%d hosts waited for the rate limit, want 1: %+v", limited, res)
+	}
+}
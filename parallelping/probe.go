@@ -0,0 +1,284 @@
+package parallelping
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"zeus/gate"
+)
+
+// 探测方式
+const (
+	PROBE_ICMP = "icmp"
+	// TCP 连接成功即为可达
+	PROBE_TCP = "tcp"
+	// UDP 收到回复为可达, 收到端口不可达或超时为不可达
+	PROBE_UDP = "udp"
+	// TCP 连接后读取ssh版本信息
+	PROBE_SSH = "ssh"
+)
+
+// 默认同时进行的探测数量
+const defaultParallel = 450
+
+func parallel(n int) int {
+	if n <= 0 {
+		return defaultParallel
+	}
+	return n
+}
+
+// Probe 探测方式和端口
+type Probe struct {
+	Type string
+	Port int
+}
+
+// ParseProbe 解析 icmp, tcp:22, udp:161, ssh, ssh:2222
+func ParseProbe(s string) (Probe, error) {
+	typ, port, hasPort := strings.Cut(strings.TrimSpace(s), ":")
+	p := Probe{Type: typ}
+
+	switch typ {
+	case PROBE_ICMP:
+		if hasPort {
+			return p, fmt.Errorf("parallelping: icmp 不需要端口: %q", s)
+		}
+		return p, nil
+	case PROBE_SSH:
+		p.Port = 22
+	case PROBE_TCP, PROBE_UDP:
+		if !hasPort {
+			return p, fmt.Errorf("parallelping: %s 需要指定端口, 例如 %s:22", typ, typ)
+		}
+	default:
+		return p, fmt.Errorf("parallelping: 未知的探测方式 %q", s)
+	}
+
+	if hasPort {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return p, fmt.Errorf("parallelping: 端口错误 %q", s)
+		}
+		p.Port = n
+	}
+	return p, nil
+}
+
+func (p Probe) String() string {
+	if p.Type == "" || p.Type == PROBE_ICMP {
+		return PROBE_ICMP
+	}
+	return p.Type + ":" + strconv.Itoa(p.Port)
+}
+
+// ProbePinger 按主机选择探测方式
+// IP文件中 host:port 格式的主机使用 Probe 的类型探测该端口(Probe为icmp时使用tcp), 其它主机使用 Probe
+type ProbePinger struct {
+	// 默认的探测方式, 为空时使用icmp
+	Probe Probe
+	// icmp 探测使用的Pinger, 为空时使用 ICMPPinger
+	ICMP Pinger
+	// TCP/UDP/SSH 每台主机探测的次数, 默认3
+	Count int
+	// TCP/UDP/SSH 单次探测的超时时间, 默认2s
+	Timeout time.Duration
+	// 同时探测的主机数量, 默认450
+	Parallel int
+	// 每秒新建连接的数量, 0 表示不限制
+	Rate float64
+	// 每个/24网段同时探测的主机数量, 0 表示不限制
+	SubnetParallel int
+}
+
+// 解析IP文件中的一行, 返回主机和探测方式
+func (p *ProbePinger) target(entry string) (string, Probe) {
+	probe := p.Probe
+	if probe.Type == "" {
+		probe.Type = PROBE_ICMP
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		// 没有端口, 包括不带[]的IPv6地址
+		return strings.Trim(entry, "[]"), probe
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil {
+		return entry, probe
+	}
+
+	if probe.Type == PROBE_ICMP {
+		probe.Type = PROBE_TCP
+	}
+	probe.Port = n
+	return host, probe
+}
+
+func (p *ProbePinger) Ping(ctx context.Context, hosts []string) ([]Stats, error) {
+	res := make([]Stats, len(hosts))
+
+	var icmpHosts []string
+	var icmpIdx []int
+
+	var wg sync.WaitGroup
+	limits := gate.NewLimits(gate.New(parallel(p.Parallel)), gate.NewLimiter(p.Rate, 1), gate.Limit{Parallel: p.SubnetParallel}, gate.Limit{})
+
+	for i, entry := range hosts {
+		host, probe := p.target(entry)
+		res[i] = Stats{IP: entry, Probe: probe.String()}
+
+		if probe.Type == PROBE_ICMP {
+			icmpHosts = append(icmpHosts, host)
+			icmpIdx = append(icmpIdx, i)
+			continue
+		}
+
+		wg.Add(1)
+		go func(s *Stats, host string, probe Probe) {
+			defer wg.Done()
+			release, err := limits.Acquire(ctx, host, "")
+			if err != nil {
+				s.Err, s.Loss = err, 100
+				return
+			}
+			defer release()
+			p.probe(ctx, s, host, probe)
+		}(&res[i], host, probe)
+	}
+
+	// ICMP 与其它探测同时进行
+	var icmpErr error
+	if len(icmpHosts) > 0 {
+		pinger := p.ICMP
+		if pinger == nil {
+			pinger = &ICMPPinger{}
+		}
+		icmpRes, err := pinger.Ping(ctx, icmpHosts)
+		if err != nil {
+			icmpErr = err
+		}
+		for j, s := range icmpRes {
+			i := icmpIdx[j]
+			s.IP, s.Probe = res[i].IP, res[i].Probe
+			res[i] = s
+		}
+	}
+
+	wg.Wait()
+	if icmpErr != nil {
+		return nil, icmpErr
+	}
+	return res, nil
+}
+
+// 对一台主机探测Count次
+func (p *ProbePinger) probe(ctx context.Context, s *Stats, host string, probe Probe) {
+	count, timeout := p.Count, p.Timeout
+	if count <= 0 {
+		count = 3
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	addr := net.JoinHostPort(host, strconv.Itoa(probe.Port))
+	var rtts []time.Duration
+	for n := 0; n < count && ctx.Err() == nil; n++ {
+		s.Sent++
+
+		var rtt time.Duration
+		var err error
+		switch probe.Type {
+		case PROBE_TCP:
+			rtt, err = probeTCP(ctx, addr, timeout)
+		case PROBE_UDP:
+			rtt, err = probeUDP(ctx, addr, timeout)
+		case PROBE_SSH:
+			rtt, s.Banner, err = probeSSH(ctx, addr, timeout)
+		}
+
+		if err != nil {
+			s.Err = err
+			continue
+		}
+		s.Err = nil
+		rtts = append(rtts, rtt)
+	}
+	s.setRTT(rtts)
+}
+
+// 连接建立的时间
+func probeTCP(ctx context.Context, addr string, timeout time.Duration) (time.Duration, error) {
+	d := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	rtt := time.Since(start)
+	conn.Close()
+	return rtt, nil
+}
+
+// 发送一个空的数据包, 等待回复
+func probeUDP(ctx context.Context, addr string, timeout time.Duration) (time.Duration, error) {
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	start := time.Now()
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 512)
+	if _, err := conn.Read(buf); err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return 0, fmt.Errorf("udp %s: port unreachable", addr)
+		}
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// 读取到ssh版本信息的时间, 返回版本信息, 例如 SSH-2.0-OpenSSH_8.0
+func probeSSH(ctx context.Context, addr string, timeout time.Duration) (time.Duration, string, error) {
+	d := net.Dialer{Timeout: timeout}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(start.Add(timeout))
+
+	// 版本信息之前可能有其它行, 见 RFC 4253 4.2
+	r := bufio.NewReader(conn)
+	for i := 0; i < 10; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, "", fmt.Errorf("ssh %s: read banner err: %w", addr, err)
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			return time.Since(start), strings.TrimRight(line, "\r\n"), nil
+		}
+	}
+	return 0, "", fmt.Errorf("ssh %s: no ssh banner", addr)
+}
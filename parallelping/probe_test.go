@@ -0,0 +1,189 @@
+package parallelping
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProbe(t *testing.T) {
+	cases := map[string]Probe{
+		"icmp":     {Type: PROBE_ICMP},
+		"tcp:22":   {Type: PROBE_TCP, Port: 22},
+		"udp:161":  {Type: PROBE_UDP, Port: 161},
+		"ssh":      {Type: PROBE_SSH, Port: 22},
+		"ssh:2222": {Type: PROBE_SSH, Port: 2222},
+	}
+	for s, want := range cases {
+		got, err := ParseProbe(s)
+		if err != nil || got != want {
+			t.Errorf("ParseProbe(%q) = %+v, %v", s, got, err)
+		}
+	}
+	for _, bad := range []string{"tcp", "tcp:x", "udp:70000", "icmp:1", "http:80"} {
+		if _, err := ParseProbe(bad); err == nil {
+			t.Errorf("ParseProbe(%q) should fail", bad)
+		}
+	}
+}
+
+func TestProbeTarget(t *testing.T) {
+	cases := []struct {
+		def         Probe
+		entry, host string
+		probe       string
+	}{
+		{Probe{}, "10.0.0.1", "10.0.0.1", "icmp"},
+		{Probe{}, "10.0.0.1:22", "10.0.0.1", "tcp:22"},
+		{Probe{Type: PROBE_UDP, Port: 53}, "10.0.0.1", "10.0.0.1", "udp:53"},
+		{Probe{Type: PROBE_SSH, Port: 22}, "[fe80::1]:2222", "fe80::1", "ssh:2222"},
+		{Probe{Type: PROBE_TCP, Port: 3306}, "fe80::1", "fe80::1", "tcp:3306"},
+	}
+	for _, c := range cases {
+		p := &ProbePinger{Probe: c.def}
+		host, probe := p.target(c.entry)
+		if host != c.host || probe.String() != c.probe {
+			t.Errorf("target(%q) = %s, %s, want %s, %s", c.entry, host, probe, c.host, c.probe)
+		}
+	}
+}
+
+// 接受连接后输出banner, banner为空时直接关闭
+func listenTCP(t *testing.T, banner string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// 已关闭的端口
+func closedPort(t *testing.T, network string) int {
+	t.Helper()
+
+	var addr net.Addr
+	if network == "udp" {
+		c, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = c.LocalAddr()
+		c.Close()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr()
+		ln.Close()
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func TestProbePinger(t *testing.T) {
+	tcpAddr := listenTCP(t, "")
+	sshAddr := listenTCP(t, "Welcome\r\nSSH-2.0-OpenSSH_8.0\r\n")
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(buf[:n], from)
+		}
+	}()
+	_, udpPort, _ := net.SplitHostPort(udp.LocalAddr().String())
+
+	p := &ProbePinger{Probe: Probe{Type: PROBE_UDP}, Count: 2, Timeout: 500 * time.Millisecond}
+	hosts := []string{
+		tcpAddr,
+		"127.0.0.1:" + strconv.Itoa(closedPort(t, "tcp")),
+		"127.0.0.1:" + udpPort,
+		"127.0.0.1:" + strconv.Itoa(closedPort(t, "udp")),
+	}
+	res, err := p.Ping(context.Background(), hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认探测方式为udp时, 带端口的主机也使用udp, 只有udp回显服务可达
+	want := []bool{false, false, true, false}
+	for i, s := range res {
+		if !strings.HasPrefix(s.Probe, "udp:") || s.OK() != want[i] {
+			t.Errorf("%s: probe = %s, ok = %v, err = %v", hosts[i], s.Probe, s.OK(), s.Err)
+		}
+		if s.IP != hosts[i] || s.Sent != 2 {
+			t.Errorf("%s: ip = %s, sent = %d", hosts[i], s.IP, s.Sent)
+		}
+	}
+
+	p = &ProbePinger{Count: 1, Timeout: 500 * time.Millisecond}
+	res, err = p.Ping(context.Background(), []string{tcpAddr, hosts[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res[0].OK() || res[0].Probe != "tcp:"+strings.Split(tcpAddr, ":")[1] || res[0].Min <= 0 {
+		t.Errorf("tcp open = %+v", res[0])
+	}
+	if res[1].OK() || res[1].Err == nil {
+		t.Errorf("tcp closed = %+v", res[1])
+	}
+
+	p = &ProbePinger{Probe: Probe{Type: PROBE_SSH, Port: 22}, Count: 1, Timeout: 500 * time.Millisecond}
+	res, err = p.Ping(context.Background(), []string{sshAddr, tcpAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res[0].OK() || res[0].Banner != "SSH-2.0-OpenSSH_8.0" {
+		t.Errorf("ssh = %+v", res[0])
+	}
+	if res[1].OK() {
+		t.Errorf("tcp without banner = %+v", res[1])
+	}
+
+	// 等待速率限制时取消, 没有探测的主机丢包率为100
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p = &ProbePinger{Count: 1, Timeout: 500 * time.Millisecond, Rate: 0.1}
+	res, err = p.Ping(ctx, []string{tcpAddr, tcpAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped := 0
+	for _, s := range res {
+		if s.Err == context.DeadlineExceeded {
+			skipped++
+			if s.Sent != 0 || s.Loss != 100 {
+				t.Errorf("skipped = %+v", s)
+			}
+		}
+	}
+	if skipped != 1 {
+		t.Errorf("skipped %d hosts, want 1: %+v", skipped, res)
+	}
+}
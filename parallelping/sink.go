@@ -0,0 +1,92 @@
+package parallelping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Sink 接收状态变化, 每轮ping调用一次
+type Sink interface {
+	Send(ctx context.Context, changes []Transition) error
+}
+
+// SinkFunc 把函数作为Sink使用
+type SinkFunc func(ctx context.Context, changes []Transition) error
+
+func (f SinkFunc) Send(ctx context.Context, changes []Transition) error {
+	return f(ctx, changes)
+}
+
+// WriterSink 每个状态变化输出一行, 用于标准输出和日志文件
+type WriterSink struct {
+	W io.Writer
+	// 输出JSON, 每行一个状态变化
+	JSON bool
+
+	mu sync.Mutex
+}
+
+func (s *WriterSink) Send(ctx context.Context, changes []Transition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range changes {
+		var err error
+		if s.JSON {
+			err = json.NewEncoder(s.W).Encode(c)
+		} else {
+			_, err = fmt.Fprintf(s.W, "%s %s %s -> %s loss=%.1f%% avg=%.3fms\n",
+				c.Time.Format("2006-01-02 15:04:05"), c.IP, c.From, c.To, c.Loss, c.AvgMs)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WebhookSink 把一轮的状态变化以JSON POST到URL
+//
+//	{"transitions": [{"ip": "10.0.0.1", "from": "up", "to": "down", ...}]}
+type WebhookSink struct {
+	URL string
+	// 为空时使用10秒超时的默认客户端
+	Client *http.Client
+}
+
+func (s *WebhookSink) Send(ctx context.Context, changes []Transition) error {
+	body, err := json.Marshal(struct {
+		Transitions []Transition `json:"transitions"`
+	}{changes})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("parallelping: webhook [%s] err: %w", s.URL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("parallelping: webhook [%s] err: %w", s.URL, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("parallelping: webhook [%s] returned %s", s.URL, resp.Status)
+	}
+	return nil
+}
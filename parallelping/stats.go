@@ -0,0 +1,75 @@
+package parallelping
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Pinger ping一组主机, 结果与hosts的顺序一致
+type Pinger interface {
+	Ping(ctx context.Context, hosts []string) ([]Stats, error)
+}
+
+// Stats 单台主机的ping结果
+type Stats struct {
+	// IP文件中的主机, 可能带端口
+	IP string
+	// 探测方式, 例如 icmp, tcp:22
+	Probe string
+	Sent  int
+	Recv  int
+	// 丢包率, 百分比
+	Loss float64
+
+	Min    time.Duration
+	Avg    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+
+	// ssh探测读取到的版本信息
+	Banner string
+
+	// 解析地址失败, 发送失败等错误
+	Err error
+}
+
+// 至少收到一个回复
+func (s Stats) OK() bool {
+	return s.Recv > 0
+}
+
+// 根据每个包的往返时间计算统计值
+// 解析地址失败或取消等原因一个包都没有发出时, 丢包率为100
+func (s *Stats) setRTT(rtts []time.Duration) {
+	s.Recv = len(rtts)
+	if s.Sent > 0 {
+		s.Loss = float64(s.Sent-s.Recv) * 100 / float64(s.Sent)
+	} else {
+		s.Loss = 100
+	}
+	if len(rtts) == 0 {
+		return
+	}
+
+	s.Min, s.Max = rtts[0], rtts[0]
+	var sum float64
+	for _, v := range rtts {
+		if v < s.Min {
+			s.Min = v
+		}
+		if v > s.Max {
+			s.Max = v
+		}
+		sum += float64(v)
+	}
+	avg := sum / float64(len(rtts))
+
+	// 与ping的mdev相同, 使用总体标准差
+	var sq float64
+	for _, v := range rtts {
+		sq += (float64(v) - avg) * (float64(v) - avg)
+	}
+	s.Avg = time.Duration(avg)
+	s.StdDev = time.Duration(math.Sqrt(sq / float64(len(rtts))))
+}
@@ -0,0 +1,158 @@
+package parallelping
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// 主机状态
+const (
+	STATE_UNKNOWN = "unknown"
+	STATE_UP      = "up"
+	STATE_DOWN    = "down"
+)
+
+// Transition 主机状态变化
+type Transition struct {
+	IP   string    `json:"ip"`
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Time time.Time `json:"time"`
+	// 最后一次ping的丢包率和平均延迟
+	Loss  float64 `json:"loss"`
+	AvgMs float64 `json:"avg_ms"`
+}
+
+// 单台主机的状态, 用于防抖
+type hostState struct {
+	state string
+	// 连续失败和连续成功的次数
+	fails int
+	rises int
+}
+
+// Watcher 定时ping所有主机, 只把状态变化发送到Sinks
+// 启动时不可达的主机也会作为 unknown -> down 发送, 启动时可达的主机不发送
+type Watcher struct {
+	Pinger   Pinger
+	Hosts    []string
+	Interval time.Duration
+	// 连续失败多少次判定为down, 默认3
+	FailThreshold int
+	// down的主机连续成功多少次恢复为up, 默认1
+	RiseThreshold int
+	Sinks         []Sink
+	// 发送失败时调用, 默认输出到标准错误
+	OnError func(error)
+
+	states map[string]*hostState
+}
+
+// Run 每隔Interval执行一次, 直到ctx取消
+func (w *Watcher) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.Sweep(ctx); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep ping一轮, 更新状态并把状态变化发送到所有Sink
+func (w *Watcher) Sweep(ctx context.Context) ([]Transition, error) {
+	res, err := w.Pinger.Ping(ctx, w.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		// 被取消的一轮结果不完整, 不更新状态
+		return nil, nil
+	}
+
+	changes := w.update(res, time.Now())
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	for _, s := range w.Sinks {
+		if err := s.Send(ctx, changes); err != nil {
+			w.onError(err)
+		}
+	}
+	return changes, nil
+}
+
+func (w *Watcher) onError(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+}
+
+// 根据一轮结果更新状态, 返回状态变化
+func (w *Watcher) update(res []Stats, now time.Time) []Transition {
+	fail, rise := w.FailThreshold, w.RiseThreshold
+	if fail <= 0 {
+		fail = 3
+	}
+	if rise <= 0 {
+		rise = 1
+	}
+	if w.states == nil {
+		w.states = make(map[string]*hostState)
+	}
+
+	changes := make([]Transition, 0)
+	for _, r := range res {
+		st, ok := w.states[r.IP]
+		if !ok {
+			st = &hostState{state: STATE_UNKNOWN}
+			w.states[r.IP] = st
+		}
+
+		from := st.state
+		if r.OK() {
+			st.fails = 0
+			st.rises++
+			// 第一次成功直接判定为up
+			if st.state == STATE_UNKNOWN || (st.state == STATE_DOWN && st.rises >= rise) {
+				st.state = STATE_UP
+			}
+		} else {
+			st.rises = 0
+			st.fails++
+			if st.state != STATE_DOWN && st.fails >= fail {
+				st.state = STATE_DOWN
+			}
+		}
+
+		if st.state == from || (from == STATE_UNKNOWN && st.state == STATE_UP) {
+			continue
+		}
+		changes = append(changes, Transition{
+			IP:    r.IP,
+			From:  from,
+			To:    st.state,
+			Time:  now,
+			Loss:  r.Loss,
+			AvgMs: ms(r.Avg),
+		})
+	}
+	return changes
+}
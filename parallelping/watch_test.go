@@ -0,0 +1,127 @@
+package parallelping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 按轮次返回预设结果, true 表示可达
+type scriptPinger struct {
+	rounds [][]bool
+	n      int
+}
+
+func (p *scriptPinger) Ping(ctx context.Context, hosts []string) ([]Stats, error) {
+	round := p.rounds[p.n]
+	p.n++
+
+	res := make([]Stats, len(hosts))
+	for i, h := range hosts {
+		res[i] = Stats{IP: h, Sent: 1, Loss: 100}
+		if round[i] {
+			res[i].Recv, res[i].Loss = 1, 0
+		}
+	}
+	return res, nil
+}
+
+func transitions(changes []Transition) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.IP+":"+c.From+"->"+c.To)
+	}
+	return s
+}
+
+func TestWatcherFlapDamping(t *testing.T) {
+	var log []string
+	w := &Watcher{
+		Hosts:         []string{"a", "b"},
+		FailThreshold: 2,
+		RiseThreshold: 2,
+		Sinks: []Sink{SinkFunc(func(ctx context.Context, changes []Transition) error {
+			log = append(log, transitions(changes)...)
+			return nil
+		})},
+		Pinger: &scriptPinger{rounds: [][]bool{
+			{true, false},  // a 启动时可达, 不输出; b 失败1次
+			{false, false}, // a 失败1次; b 连续失败2次 -> down
+			{true, false},  // a 恢复, 没有达到失败次数
+			{false, true},  // b 成功1次, 没有达到恢复次数
+			{false, true},  // a 连续失败2次 -> down; b 连续成功2次 -> up
+			{true, true},   // a 成功1次
+		}},
+	}
+
+	for i := 0; i < 6; i++ {
+		if _, err := w.Sweep(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"b:unknown->down", "a:up->down", "b:down->up"}
+	if strings.Join(log, ",") != strings.Join(want, ",") {
+		t.Fatalf("transitions = %v, want %v", log, want)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got []Transition
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body struct{ Transitions []Transition }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got = append(got, body.Transitions...)
+	}))
+	defer srv.Close()
+
+	changes := []Transition{{IP: "10.0.0.1", From: STATE_UP, To: STATE_DOWN, Loss: 100}}
+	if err := (&WebhookSink{URL: srv.URL}).Send(context.Background(), changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].IP != "10.0.0.1" || got[0].To != STATE_DOWN {
+		t.Fatalf("webhook received %+v", got)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if err := (&WebhookSink{URL: srv.URL}).Send(context.Background(), changes); err == nil {
+		t.Fatal("expected error for 500 response")
+	}
+}
+
+func TestWatcherSinkError(t *testing.T) {
+	var out bytes.Buffer
+	var errs []error
+	w := &Watcher{
+		Hosts:         []string{"a"},
+		FailThreshold: 1,
+		Pinger:        &scriptPinger{rounds: [][]bool{{false}}},
+		Sinks: []Sink{
+			SinkFunc(func(ctx context.Context, changes []Transition) error { return errors.New("db down") }),
+			&WriterSink{W: &out},
+		},
+		OnError: func(err error) { errs = append(errs, err) },
+	}
+
+	if _, err := w.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 一个sink失败不影响其它sink
+	if len(errs) != 1 || !strings.Contains(out.String(), "a unknown -> down") {
+		t.Fatalf("errs = %v, out = %q", errs, out.String())
+	}
+}
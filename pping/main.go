@@ -1,13 +1,39 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"zeus/model"
 	ping "zeus/parallelping"
 )
 
 var (
-	f = flag.String("f", "", "文件名称")
+	f          = flag.String("f", "", "文件名称")
+	backend    = flag.String("backend", "native", "ping方式: native(直接发送ICMP), exec(调用系统ping命令)")
+	count      = flag.Int("c", 3, "每个IP发送的包数")
+	interval   = flag.Duration("i", time.Second, "native: 两次发送之间的间隔")
+	timeout    = flag.Duration("W", 2*time.Second, "等待回复的时间: icmp为最后一个包发出后的等待时间, tcp/udp/ssh为单次探测的超时时间")
+	privileged = flag.Bool("privileged", false, "native: 只使用raw socket")
+	output     = flag.String("o", "text", "输出格式: text, table, json, csv, ndjson; csv和ndjson的汇总输出到标准错误")
+	sortBy     = flag.String("sort", "input", "结果排序: input(文件中的顺序), ip")
+	parallel   = flag.Int("parallel", 450, "exec, tcp, udp, ssh: 同时探测的主机数量")
+	rate       = flag.Float64("rate", 0, "每秒新建连接, 发送的ICMP包或启动的ping进程(exec)数量, 0 表示不限制")
+	subnetPar  = flag.Int("subnet-parallel", 0, "tcp, udp, ssh: 每个/24网段同时探测的主机数量, 0 表示不限制")
+	probe      = flag.String("probe", "icmp", "探测方式: icmp, tcp:端口, udp:端口, ssh[:端口]; IP文件中 host:port 格式的主机探测该端口")
+
+	watch   = flag.Duration("watch", 0, "监控模式: 每隔指定时间ping一次, 只输出状态变化, 例如 1m")
+	fails   = flag.Int("fail", 3, "监控模式: 连续失败多少次判定为down")
+	rises   = flag.Int("rise", 1, "监控模式: down的主机连续成功多少次恢复为up")
+	logFile = flag.String("log", "", "监控模式: 状态变化追加写入的日志文件")
+	webhook = flag.String("webhook", "", "监控模式: 状态变化POST到的URL")
+	toDB    = flag.Bool("db", false, "监控模式: 状态变化写入idc数据库的 machine_ping_event 表")
+	dsn     = flag.String("dsn", "", "idc数据库连接串(mysql:// 或 sqlite://), 默认使用环境变量 "+model.ENV_DB_DSN+" 或配置文件中的dsn")
+	dbConf  = flag.String("db-config", "", "idc数据库配置文件(YAML), 默认使用环境变量 "+model.ENV_DB_CONFIG)
 )
 
 func main() {
@@ -18,7 +44,136 @@ func main() {
 		return
 	}
 
+	var p ping.Pinger
+	switch *backend {
+	case "native":
+		p = &ping.ICMPPinger{Count: *count, Interval: *interval, Timeout: *timeout, Privileged: *privileged, Rate: *rate}
+	case "exec":
+		p = &ping.ExecPinger{Count: *count, Parallel: *parallel, Rate: *rate}
+	default:
+		fmt.Printf("未知的ping方式: %s\n", *backend)
+		os.Exit(2)
+	}
+
+	pr, err := ping.ParseProbe(*probe)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	p = &ping.ProbePinger{Probe: pr, ICMP: p, Count: *count, Timeout: *timeout, Parallel: *parallel, Rate: *rate, SubnetParallel: *subnetPar}
+
+	switch *output {
+	case ping.FORMAT_TEXT, ping.FORMAT_TABLE, ping.FORMAT_JSON, ping.FORMAT_CSV, ping.FORMAT_NDJSON:
+	default:
+		fmt.Printf("未知的输出格式: %s\n", *output)
+		os.Exit(2)
+	}
+
+	if *sortBy != "input" && *sortBy != "ip" {
+		fmt.Printf("未知的排序方式: %s\n", *sortBy)
+		os.Exit(2)
+	}
+
+	if *f != "" && *watch > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if err := runWatch(ctx, p); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *f != "" {
-		ping.ParallelPing(*f)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		res, err := ping.ParallelPing(ctx, *f, p)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if *sortBy == "ip" {
+			ping.SortByIP(res)
+		}
+
+		if err := ping.Write(os.Stdout, *output, res); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if *output == ping.FORMAT_CSV || *output == ping.FORMAT_NDJSON {
+			ping.WriteSummary(os.Stderr, ping.Summarize(res))
+		}
 	}
 }
+
+// 监控模式, 直到收到中断信号
+func runWatch(ctx context.Context, p ping.Pinger) error {
+	hosts, err := ping.ReadIPFile(*f)
+	if err != nil {
+		return err
+	}
+
+	w := &ping.Watcher{
+		Pinger:        p,
+		Hosts:         hosts,
+		Interval:      *watch,
+		FailThreshold: *fails,
+		RiseThreshold: *rises,
+		Sinks: []ping.Sink{
+			&ping.WriterSink{W: os.Stdout, JSON: *output == ping.FORMAT_JSON || *output == ping.FORMAT_NDJSON},
+		},
+	}
+
+	if *logFile != "" {
+		lf, err := os.OpenFile(*logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer lf.Close()
+		w.Sinks = append(w.Sinks, &ping.WriterSink{W: lf})
+	}
+
+	if *webhook != "" {
+		w.Sinks = append(w.Sinks, &ping.WebhookSink{URL: *webhook})
+	}
+
+	if *toDB {
+		cfg, err := model.LoadConfig(*dsn, *dbConf)
+		if err != nil {
+			return err
+		}
+		store, err := model.Open(cfg)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		// 表结构不是最新版本时启动即失败, 而不是等到第一次状态变化才写入失败
+		if err := store.CheckSchema(); err != nil {
+			return err
+		}
+		w.Sinks = append(w.Sinks, dbSink(store))
+	}
+
+	return w.Run(ctx)
+}
+
+// 状态变化写入数据库
+func dbSink(store model.Store) ping.Sink {
+	return ping.SinkFunc(func(ctx context.Context, changes []ping.Transition) error {
+		events := make([]model.Ping_Event_MODEL, 0, len(changes))
+		for _, c := range changes {
+			events = append(events, model.Ping_Event_MODEL{
+				IP:        c.IP,
+				FromState: c.From,
+				ToState:   c.To,
+				Loss:      c.Loss,
+				AvgMs:     c.AvgMs,
+				ChangedAt: c.Time,
+			})
+		}
+		return store.WritePingEvents(events)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	db "zeus/model"
+)
+
+const dbUsage = `用法: prun db <migrate|status|rollback> [-dsn DSN] [-db-config FILE] [-steps N]
+
+  migrate   按版本执行所有未执行的升级脚本
+  status    列出升级脚本及其执行状态
+  rollback  回滚最近执行的 -steps 个升级脚本
+`
+
+// 管理idc数据库的表结构, 返回进程退出码
+func runDB(args []string) int {
+	fs := flag.NewFlagSet("prun db", flag.ContinueOnError)
+	dsn := fs.String("dsn", "", "idc数据库连接串(mysql:// 或 sqlite://), 默认使用环境变量 "+db.ENV_DB_DSN+" 或配置文件中的dsn")
+	dbConf := fs.String("db-config", "", "idc数据库配置文件(YAML), 默认使用环境变量 "+db.ENV_DB_CONFIG)
+	steps := fs.Int("steps", 1, "rollback 回滚的版本数量")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), dbUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+	action := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	switch action {
+	case "migrate", "status", "rollback":
+	default:
+		fmt.Fprintf(os.Stderr, "未知的db命令: %s\n", action)
+		fs.Usage()
+		return 2
+	}
+	if action == "rollback" && *steps < 1 {
+		fmt.Fprintln(os.Stderr, "-steps 必须大于0")
+		return 2
+	}
+
+	cfg, err := db.LoadConfig(*dsn, *dbConf)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	store, err := db.Open(cfg)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer store.Close()
+
+	switch action {
+	case "migrate":
+		done, err := store.Migrate()
+		for _, m := range done {
+			fmt.Printf("migrate: [%04d_%s] OK\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("数据库表结构已是最新版本")
+		}
+
+	case "status":
+		status, err := store.MigrationStatus()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		for _, m := range status {
+			applied := "未执行"
+			if m.Applied {
+				applied = m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-24s %s\n", m.Version, m.Name, applied)
+		}
+
+	case "rollback":
+		done, err := store.Rollback(*steps)
+		for _, m := range done {
+			fmt.Printf("rollback: [%04d_%s] OK\n", m.Version, m.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Println("没有可以回滚的版本")
+		}
+	}
+	return 0
+}
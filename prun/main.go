@@ -2,11 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"zeus/gate"
+	"zeus/inventory"
 	"zeus/kwssh"
+	db "zeus/model"
+
+	"golang.org/x/term"
 )
 
 // 自定义类型实现flag.Value接口
@@ -22,28 +31,119 @@ func (i *ipList) Set(value string) error {
 }
 
 var (
-	ips      ipList
-	filename = flag.String("filename", "", "批量执行命令的IP文件")
-	username = flag.String("username", "root", "用户名")
-	password = flag.String("password", "", "密码")
-	port     = flag.Int("port", 22, "端口号")
-	command  = flag.String("command", "", "要执行的命令")
-	key      = flag.String("key", "", "私钥路径")
+	ips       ipList
+	puts      ipList
+	gets      ipList
+	filename  = flag.String("filename", "", "批量执行命令的IP文件")
+	username  = flag.String("username", "root", "用户名")
+	password  = flag.String("password", "", "密码")
+	port      = flag.Int("port", 22, "端口号")
+	command   = flag.String("command", "", "要执行的命令")
+	key       = flag.String("key", "", "私钥路径, 多个用逗号分隔")
+	authList  = flag.String("auth", "", "按顺序尝试的认证方式, 逗号分隔: agent, publickey, password, keyboard-interactive")
+	passEnv   = flag.String("passphrase-env", "KWSSH_PASSPHRASE", "私钥passphrase所在的环境变量, 为空时在终端输入")
+	hostKey   = flag.String("hostkey", "strict", "主机公钥校验策略: strict(严格校验known_hosts), tofu(首次信任), insecure(不校验)")
+	known     = flag.String("known_hosts", "", "known_hosts文件路径, 默认 ~/.ssh/known_hosts")
+	playbook  = flag.String("playbook", "", "playbook文件(YAML/JSON), 按顺序执行其中的每个play")
+	cmdTime   = flag.Duration("cmd-timeout", 0, "单条命令的超时时间, 例如 5m, 0 表示不限制")
+	taskTime  = flag.Duration("task-timeout", 0, "单台主机所有命令的超时时间, 0 表示不限制")
+	invFile   = flag.String("inventory", "", "主机清单: INI/YAML 文件, 或 db 表示从idc数据库读取")
+	limit     = flag.String("limit", "", "按组名, 主机通配符或标签(key=value)选择主机, 逗号分隔, !开头表示排除")
+	parallel  = flag.Int("parallel", 5, "同时执行的主机数量")
+	rate      = flag.Float64("rate", 0, "每秒新建ssh连接的数量, 0 表示不限制")
+	subnetPar = flag.Int("subnet-parallel", 0, "每个/24网段同时执行的主机数量, 0 表示不限制")
+	jumpPar   = flag.Int("jump-parallel", 0, "经过同一个跳板机同时执行的主机数量, 0 表示不限制")
+	batch     = flag.String("batch", "", "分批执行, 每批的主机数量或百分比, 例如 10 或 20%; 每批结束后才开始下一批")
+	canary    = flag.Bool("canary", false, "先在第一台主机上执行, 成功后再执行其余主机")
+	maxFail   = flag.Int("max-failures", 0, "分批执行时累计失败超过该数量后不再执行剩余批次, -1 表示不中止")
+	jump      = flag.String("jump", "", "跳板机, 格式 [user@]host[:port], 多个用逗号分隔按顺序经过; 主机清单中的jump优先")
+	mode      = flag.String("mode", "", "-put 上传后的文件权限, 八进制, 例如 0644, 默认与本地文件相同")
+	owner     = flag.String("owner", "", "-put 上传后的属主, 例如 root:root")
+	output    = flag.String("o", "text", "fetch 的输出格式: text, json, yaml, csv(每台主机一行); prun diff 的输出格式: text, json")
+	dsn       = flag.String("dsn", "", "idc数据库连接串(mysql:// 或 sqlite://), 用于 fetchToDB, prun diff 和 -inventory db, 默认使用环境变量 "+db.ENV_DB_DSN+" 或配置文件中的dsn")
+	dbConf    = flag.String("db-config", "", "idc数据库配置文件(YAML), 默认使用环境变量 "+db.ENV_DB_CONFIG)
+	sheets    = flag.String("sheets", "", "fetch 时在该目录下输出 hosts.csv 以及内存, 磁盘, RAID, 电源每个组件一行的csv")
 )
 
 func main() {
 
-	b1 := kwssh.New("n1", 5)
-	flag.Var(&ips, "ip", "IP 地址列表，可以提供多个")
+	// prun db migrate|status|rollback 管理idc数据库表结构
+	if len(os.Args) > 1 && os.Args[1] == "db" {
+		os.Exit(runDB(os.Args[2:]))
+	}
 
-	// 解析命令行参数
-	flag.Parse()
+	flag.Var(&ips, "ip", "IP 地址列表，可以提供多个")
+	flag.Var(&puts, "put", "上传文件或目录, 格式 本地路径:远程路径, 可以提供多个, 在命令之前执行")
+	flag.Var(&gets, "get", "下载文件或目录, 格式 远程路径:本地目录, 保存到 本地目录/<IP>/ 下, 可以提供多个")
 
 	if len(os.Args) == 1 {
 		flag.Usage()
 		return
 	}
-	task := kwssh.Task{}
+
+	// prun diff [参数] 采集机器信息与idc数据库对比, 主机和登录参数与执行命令相同
+	diffMode := os.Args[1] == "diff"
+
+	// 解析命令行参数
+	if diffMode {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
+
+	if diffMode && (*command != "" || *playbook != "" || len(puts) != 0 || len(gets) != 0) {
+		fmt.Println("prun diff 不能与 -command, -playbook, -put, -get 一起使用")
+		return
+	}
+
+	// playbook 中每个play有自己的分批策略, 命令行的设置不会生效
+	if *playbook != "" {
+		var set []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "batch" || f.Name == "canary" || f.Name == "max-failures" {
+				set = append(set, "-"+f.Name)
+			}
+		})
+		if len(set) != 0 {
+			fmt.Printf("-playbook 不能与 %s 一起使用, 请在play中设置 batch, canary, max_failures\n", strings.Join(set, ", "))
+			return
+		}
+	}
+
+	b1 := kwssh.New("n1", *parallel)
+	b1.SetLimits(*rate, 1, gate.Limit{Parallel: *subnetPar}, gate.Limit{Parallel: *jumpPar})
+
+	batchSize, batchPercent, err := kwssh.ParseBatch(*batch)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	b1.SetStrategy(kwssh.Strategy{BatchSize: batchSize, BatchPercent: batchPercent, Canary: *canary, MaxFailures: *maxFail})
+
+	// Ctrl-C 取消未完成的主机, 已完成的结果照常输出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	policy, err := kwssh.ParseHostKeyPolicy(*hostKey)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	b1.SetHostKeyPolicy(policy, *known)
+
+	if *playbook != "" {
+		if !runPlayBookFile(ctx, *playbook, policy) {
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
+	task := kwssh.Task{
+		CommandTimeout: *cmdTime,
+		TaskTimeout:    *taskTime,
+		ProxyJump:      splitJump(*jump),
+	}
 
 	if *username != "" {
 		task.User = *username
@@ -61,8 +161,16 @@ func main() {
 
 	if *key != "" {
 		// 使用公钥登录
+		keys := strings.Split(*key, ",")
 		task.SSHType = kwssh.PUBLICKEY
-		task.KeyPath = *key
+		task.KeyPath = keys[0]
+		task.KeyPaths = keys[1:]
+
+		task.Passphrase, err = readPassphrase(keys)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
 	}
 
 	if *password != "" {
@@ -71,8 +179,27 @@ func main() {
 		task.Pass = *password
 	}
 
-	if *key == "" && *password == "" {
-		fmt.Println("请指定密码或key登录")
+	if *authList != "" {
+		task.AuthMethods, err = kwssh.ParseAuthMethods(*authList)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if *key == "" && *password == "" && len(task.AuthMethods) == 0 {
+		if os.Getenv("SSH_AUTH_SOCK") != "" {
+			// 没有指定密码和key时使用ssh-agent
+			task.AuthMethods = []int{kwssh.AGENT}
+		} else if *invFile == "" {
+			fmt.Println("请指定密码或key登录")
+			return
+		}
+	}
+
+	task.Copies, err = copySteps()
+	if err != nil {
+		fmt.Println(err)
 		return
 	}
 
@@ -80,44 +207,312 @@ func main() {
 
 		task.Command = []string{*command}
 
-	} else {
+	} else if len(task.Copies) == 0 && !diffMode {
 		fmt.Println("执行命令不能为空")
 		return
 	}
 
-	if len(ips) != 0 {
-		for _, v := range ips {
-			task.IP = v
-			b1.AddTask("n1", task)
+	hosts, err := selectHosts()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, h := range hosts {
+		if err := b1.AddTask("n1", hostTask(task, h)); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if diffMode {
+		if err := diffFetch(ctx, b1); err != nil {
+			// json 输出到标准输出, 错误不混在其中
+			fmt.Fprintln(os.Stderr, err)
+			stop()
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch *command {
+	case "fetch":
+		var err error
+		if *output == kwssh.FORMAT_TEXT && *sheets == "" {
+			err = b1.FetchInfo(ctx)
+		} else {
+			err = exportFetch(ctx, b1)
+		}
+		if err != nil {
+			// json 等格式输出到标准输出, 错误不混在其中
+			fmt.Fprintln(os.Stderr, err)
+			stop()
+			os.Exit(1)
+		}
+	case "fetchToDB":
+		store, err := openStore()
+		if err != nil {
+			fmt.Println(err)
+			stop()
+			os.Exit(1)
+		}
+		err = b1.FetchInfoToDB(ctx, store)
+		store.Close()
+		if err != nil {
+			fmt.Println(err)
+			stop()
+			os.Exit(1)
+		}
+	default:
+		if err := b1.Run(ctx); err != nil {
+			fmt.Println(err)
+			stop()
+			os.Exit(1)
 		}
 	}
+}
 
-	if *filename != "" {
-		data, err := os.Open(*filename)
+// 有私钥被passphrase保护时, 从环境变量读取passphrase, 环境变量为空时在终端输入
+// 所有私钥使用同一个passphrase
+func readPassphrase(keys []string) (string, error) {
+	encrypted := false
+	for _, k := range keys {
+		ok, err := kwssh.IsKeyEncrypted(k)
 		if err != nil {
-			fmt.Printf("read ip list file err, err=%#v", err)
-			return
+			return "", fmt.Errorf("read key [%s] err, err=%#v", k, err.Error())
+		}
+		encrypted = encrypted || ok
+	}
+	if !encrypted {
+		return "", nil
+	}
+
+	if *passEnv != "" && os.Getenv(*passEnv) != "" {
+		return os.Getenv(*passEnv), nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("私钥需要passphrase, 请设置环境变量 %s", *passEnv)
+	}
+	fmt.Fprint(os.Stderr, "Enter passphrase for key: ")
+	pass, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(pass), nil
+}
+
+// 读取主机清单并按 -limit 选择主机
+// -ip 和 -filename 中的主机支持范围(10.0.0.[1-50])和CIDR(10.0.0.0/24)
+func selectHosts() ([]*inventory.Host, error) {
+	var inv *inventory.Inventory
+	var err error
+
+	if *invFile == "db" {
+		store, err := openStore()
+		if err != nil {
+			return nil, err
 		}
+		defer store.Close()
 
-		scanner := bufio.NewScanner(data)
+		inv, err = inventory.LoadDB(store)
+		if err != nil {
+			return nil, err
+		}
+	} else if *invFile != "" {
+		inv, err = inventory.Load(*invFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		exprs := append([]string(nil), ips...)
 
-		for scanner.Scan() {
-			if scanner.Text() == "" {
-				continue
+		if *filename != "" {
+			data, err := os.Open(*filename)
+			if err != nil {
+				return nil, fmt.Errorf("read ip list file err, err=%#v", err)
 			}
+			defer data.Close()
 
-			task.IP = strings.Trim(scanner.Text(), " ")
-			b1.AddTask("n1", task)
+			scanner := bufio.NewScanner(data)
+			for scanner.Scan() {
+				line := strings.Trim(scanner.Text(), " ")
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				exprs = append(exprs, line)
+			}
 		}
 
+		inv, err = inventory.FromHosts(exprs)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	switch *command {
-	case "fetch":
-		b1.FetchInfo()
-	case "fetchToDB":
-		b1.FetchInfoToDB()
+	return inv.Select(*limit)
+}
+
+// 主机变量覆盖命令行中的端口, 用户和私钥
+func hostTask(task kwssh.Task, h *inventory.Host) kwssh.Task {
+	task.IP = h.Addr
+	if h.Port != 0 {
+		task.Port = h.Port
+	}
+	if h.User != "" {
+		task.User = h.User
+	}
+	if h.Key != "" {
+		task.SSHType = kwssh.PUBLICKEY
+		task.KeyPath = h.Key
+	}
+	if h.Jump != "" {
+		task.ProxyJump = splitJump(h.Jump)
+	}
+	return task
+}
+
+// 解析 -put 和 -get, 先上传后下载
+func copySteps() ([]kwssh.CopyStep, error) {
+	var fileMode os.FileMode
+	if *mode != "" {
+		m, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil || m > 07777 {
+			return nil, fmt.Errorf("-mode 格式错误 %q, 应为八进制, 例如 0644", *mode)
+		}
+		fileMode = os.FileMode(m)
+	}
+
+	var steps []kwssh.CopyStep
+	for _, v := range puts {
+		step, err := kwssh.ParseCopy(kwssh.UPLOAD, v)
+		if err != nil {
+			return nil, err
+		}
+		step.Mode = fileMode
+		step.Owner = *owner
+		steps = append(steps, step)
+	}
+	for _, v := range gets {
+		step, err := kwssh.ParseCopy(kwssh.DOWNLOAD, v)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// 采集所有主机后按IP排序, 以 -o 指定的格式输出到标准输出, 指定 -sheets 时同时输出每个组件的csv
+func exportFetch(ctx context.Context, pb *kwssh.PlayBook) error {
+	switch *output {
+	case kwssh.FORMAT_TEXT, kwssh.FORMAT_JSON, kwssh.FORMAT_YAML, kwssh.FORMAT_CSV:
 	default:
-		b1.Run()
+		return fmt.Errorf("-o 格式错误 %q, 可选 text, json, yaml, csv", *output)
+	}
+
+	var res []kwssh.FetchResult
+	for r := range pb.Fetch(ctx) {
+		res = append(res, r)
+	}
+	kwssh.SortFetchResults(res)
+
+	if *sheets != "" {
+		if err := kwssh.WriteDetailSheets(*sheets, res); err != nil {
+			return err
+		}
+	}
+	if err := kwssh.WriteDetails(os.Stdout, *output, res); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range res {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d/%d 台主机采集失败", failed, len(res))
+	}
+	return nil
+}
+
+// 采集机器信息并与数据库中的信息对比, 不写入数据库
+func diffFetch(ctx context.Context, pb *kwssh.PlayBook) error {
+	if *output != kwssh.FORMAT_TEXT && *output != kwssh.FORMAT_JSON {
+		return fmt.Errorf("-o 格式错误 %q, diff 可选 text, json", *output)
+	}
+
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	res := pb.Diff(ctx, store)
+	if err := kwssh.WriteDiffs(os.Stdout, *output, res); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, d := range res {
+		if d.Status == kwssh.DIFF_FAILED {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d/%d 台主机采集失败", failed, len(res))
+	}
+	return nil
+}
+
+// 按 -dsn, 环境变量和 -db-config 连接idc数据库
+func openStore() (db.Store, error) {
+	cfg, err := db.LoadConfig(*dsn, *dbConf)
+	if err != nil {
+		return nil, err
+	}
+	return db.Open(cfg)
+}
+
+// 逗号分隔的跳板机列表
+func splitJump(s string) []string {
+	var hops []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			hops = append(hops, v)
+		}
 	}
+	return hops
+}
+
+// 执行playbook文件, 文件校验失败时不会连接任何主机
+// 全部主机执行成功时返回true
+func runPlayBookFile(ctx context.Context, path string, policy int) bool {
+	pbs, err := kwssh.LoadPlayBooks(path)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	ok := true
+	for _, pb := range pbs {
+		if ctx.Err() != nil {
+			fmt.Printf("PLAY [%s] 已取消\n", pb.Name())
+			ok = false
+			continue
+		}
+
+		fmt.Printf("PLAY [%s]\n", pb.Name())
+		pb.SetHostKeyPolicy(policy, *known)
+		pb.SetLimits(*rate, 1, gate.Limit{Parallel: *subnetPar}, gate.Limit{Parallel: *jumpPar})
+		if err := pb.Run(ctx); err != nil {
+			fmt.Println(err)
+			ok = false
+		}
+	}
+
+	return ok
 }